@@ -0,0 +1,423 @@
+package validator
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/chop-dbhi/data-models-service/client"
+)
+
+// DefaultCacheDir returns the directory schema information is cached in
+// by default.
+func DefaultCacheDir() string {
+	dir, err := os.UserCacheDir()
+
+	if err != nil {
+		dir = os.TempDir()
+	}
+
+	return filepath.Join(dir, "data-models-validator")
+}
+
+// CacheEntry is a model revision stored in the schema cache.
+type CacheEntry struct {
+	Source  string         `json:"source"`
+	Name    string         `json:"name"`
+	Version string         `json:"version"`
+	Fetched time.Time      `json:"fetched"`
+	Model   *client.Model  `json:"model"`
+	Schema  *client.Schema `json:"schema,omitempty"`
+
+	// FieldOrder lists the fields of each table in the order the model
+	// declares them, since the fields of the model are sorted by name.
+	FieldOrder map[string][]string `json:"fieldOrder,omitempty"`
+}
+
+// SchemaCache stores model revisions on disk. Entries are keyed by the
+// source they were fetched from (such as the service URL), the model
+// name and the version.
+type SchemaCache struct {
+	Dir string
+}
+
+func (c *SchemaCache) sourceDir(source string) string {
+	h := sha1.Sum([]byte(source))
+	return filepath.Join(c.Dir, hex.EncodeToString(h[:8]))
+}
+
+func (c *SchemaCache) path(source, name, version string) string {
+	return filepath.Join(c.sourceDir(source), strings.ToLower(name), strings.ToLower(version)+".json")
+}
+
+func readCacheEntry(path string) (*CacheEntry, error) {
+	b, err := ioutil.ReadFile(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var e CacheEntry
+
+	if err = json.Unmarshal(b, &e); err != nil {
+		return nil, fmt.Errorf("error decoding cache entry %s: %s", path, err)
+	}
+
+	if e.Model == nil || e.Model.Tables == nil {
+		return nil, fmt.Errorf("cache entry %s has no tables", path)
+	}
+
+	e.Model.Schema = e.Schema
+	linkModel(e.Model)
+
+	for name, names := range e.FieldOrder {
+		if t := e.Model.Tables.Get(name); t != nil {
+			setFieldOrder(t.Fields, names)
+		}
+	}
+
+	return &e, nil
+}
+
+// Get returns a cached model revision. If the revision is not cached,
+// nil is returned.
+func (c *SchemaCache) Get(source, name, version string) (*client.Model, error) {
+	e, err := readCacheEntry(c.path(source, name, version))
+
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+
+		return nil, err
+	}
+
+	return e.Model, nil
+}
+
+// Revisions returns all cached revisions of a model. If no revisions are
+// cached, nil is returned.
+func (c *SchemaCache) Revisions(source, name string) (*client.Models, error) {
+	paths, err := filepath.Glob(filepath.Join(c.sourceDir(source), strings.ToLower(name), "*.json"))
+
+	if err != nil || len(paths) == 0 {
+		return nil, err
+	}
+
+	models := new(client.Models)
+
+	for _, path := range paths {
+		e, err := readCacheEntry(path)
+
+		if err != nil {
+			return nil, err
+		}
+
+		models.Add(e.Model)
+	}
+
+	return models, nil
+}
+
+// Put stores a model revision in the cache.
+func (c *SchemaCache) Put(source string, m *client.Model) error {
+	path := c.path(source, m.Name, m.Version)
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	order := make(map[string][]string)
+
+	for _, t := range m.Tables.List() {
+		if t.Fields == nil {
+			continue
+		}
+
+		for _, f := range FieldOrder(t.Fields) {
+			order[t.Name] = append(order[t.Name], f.Name)
+		}
+	}
+
+	b, err := json.Marshal(&CacheEntry{
+		Source:     source,
+		Name:       m.Name,
+		Version:    m.Version,
+		Fetched:    time.Now(),
+		Model:      m,
+		Schema:     m.Schema,
+		FieldOrder: order,
+	})
+
+	if err != nil {
+		return err
+	}
+
+	// Write to a temporary file first so concurrent runs never read
+	// a partially written entry.
+	tmp := path + ".tmp"
+
+	if err = ioutil.WriteFile(tmp, b, 0644); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, path)
+}
+
+// Entries returns all entries in the cache sorted by source, name and version.
+func (c *SchemaCache) Entries() ([]*CacheEntry, error) {
+	paths, err := filepath.Glob(filepath.Join(c.Dir, "*", "*", "*.json"))
+
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []*CacheEntry
+
+	for _, path := range paths {
+		e, err := readCacheEntry(path)
+
+		if err != nil {
+			return nil, err
+		}
+
+		entries = append(entries, e)
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		a, b := entries[i], entries[j]
+
+		if a.Source != b.Source {
+			return a.Source < b.Source
+		}
+
+		if a.Name != b.Name {
+			return a.Name < b.Name
+		}
+
+		return a.Version < b.Version
+	})
+
+	return entries, nil
+}
+
+// Clear removes cached revisions. If source is empty, all sources are cleared.
+// If name is empty, all models for the source(s) are cleared.
+func (c *SchemaCache) Clear(source, name string) error {
+	dirs := []string{c.Dir}
+
+	if source != "" {
+		dirs = []string{c.sourceDir(source)}
+	}
+
+	if name != "" {
+		if source == "" {
+			var err error
+
+			if dirs, err = filepath.Glob(filepath.Join(c.Dir, "*", strings.ToLower(name))); err != nil {
+				return err
+			}
+		} else {
+			dirs = []string{filepath.Join(dirs[0], strings.ToLower(name))}
+		}
+	}
+
+	for _, dir := range dirs {
+		if err := os.RemoveAll(dir); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// linkModel sets the references between the model, tables and fields which
+// are not preserved when a model is encoded.
+func linkModel(m *client.Model) {
+	for _, t := range m.Tables.List() {
+		t.Model = m
+
+		if t.Fields == nil {
+			t.Fields = new(client.Fields)
+		}
+
+		for _, f := range t.Fields.List() {
+			f.Table = t
+		}
+	}
+
+	if m.Schema == nil {
+		return
+	}
+
+	for _, fk := range m.Schema.ForeignKeys {
+		t := m.Tables.Get(fk.SourceTable)
+		rt := m.Tables.Get(fk.TargetTable)
+
+		if t == nil || rt == nil {
+			continue
+		}
+
+		f := t.Fields.Get(fk.SourceField)
+		rf := rt.Fields.Get(fk.TargetField)
+
+		if f == nil || rf == nil || f.References != nil {
+			continue
+		}
+
+		f.References = &client.Reference{
+			Name:  fk.Name,
+			Field: rf,
+		}
+
+		rf.InboundRefs = append(rf.InboundRefs, &client.Reference{
+			Name:  fk.Name,
+			Field: f,
+		})
+	}
+}
+
+// CachedProvider wraps a provider and caches the revisions it returns.
+// Requests that fail with a temporary error are retried with an exponential
+// backoff and if the provider is still failing, the cached revisions are
+// used. Other errors, such as a revision that does not exist, are not
+// retried.
+type CachedProvider struct {
+	Provider SchemaProvider
+	Cache    *SchemaCache
+
+	// Source identifies the provider in the cache, such as the service URL.
+	Source string
+
+	// If true, cached revisions are only used if the provider fails.
+	Refresh bool
+
+	// Number of times a failed request is retried and the delay before
+	// the first retry. The delay doubles on each subsequent retry.
+	Retries int
+	Backoff time.Duration
+}
+
+// temporary returns true if a request that failed with the error may
+// succeed if retried: network errors, server errors and truncated or
+// malformed responses.
+func temporary(err error) bool {
+	var serr *ServiceError
+
+	if errors.As(err, &serr) {
+		return serr.StatusCode >= 500
+	}
+
+	var nerr net.Error
+
+	if errors.As(err, &nerr) {
+		return true
+	}
+
+	var jerr *json.SyntaxError
+
+	return errors.As(err, &jerr) || errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+func (p *CachedProvider) retry(fn func() error) error {
+	var err error
+
+	wait := p.Backoff
+
+	for i := 0; i <= p.Retries; i++ {
+		if i > 0 {
+			time.Sleep(wait)
+			wait *= 2
+		}
+
+		if err = fn(); err == nil || !temporary(err) {
+			return err
+		}
+	}
+
+	return err
+}
+
+// ModelRevisions implements the SchemaProvider interface. The provider is
+// always queried so new revisions are found. The cached revisions are only
+// returned if the provider fails. Listed revisions without a schema, such
+// as those of the service, are not cached so they never replace the full
+// revisions cached by ModelRevision.
+func (p *CachedProvider) ModelRevisions(name string) (*client.Models, error) {
+	var revisions *client.Models
+
+	err := p.retry(func() error {
+		var err error
+		revisions, err = p.Provider.ModelRevisions(name)
+		return err
+	})
+
+	if err == nil {
+		for _, m := range revisions.List() {
+			if m.Schema == nil {
+				continue
+			}
+
+			if cerr := p.Cache.Put(p.Source, m); cerr != nil {
+				return nil, cerr
+			}
+		}
+
+		return revisions, nil
+	}
+
+	cached, cerr := p.Cache.Revisions(p.Source, name)
+
+	if cerr != nil || cached == nil {
+		return nil, err
+	}
+
+	return cached, nil
+}
+
+// ModelRevision implements the SchemaProvider interface. A cached revision
+// is returned without querying the provider unless Refresh is set. Cached
+// revisions without a schema are only used if the provider fails.
+func (p *CachedProvider) ModelRevision(name, version string) (*client.Model, error) {
+	cached, cerr := p.Cache.Get(p.Source, name, version)
+
+	if cached != nil && cached.Schema != nil && !p.Refresh {
+		return cached, nil
+	}
+
+	var model *client.Model
+
+	err := p.retry(func() error {
+		var err error
+		model, err = p.Provider.ModelRevision(name, version)
+		return err
+	})
+
+	if err != nil {
+		if cached != nil {
+			return cached, nil
+		}
+
+		if cerr != nil {
+			return nil, fmt.Errorf("%s (cache: %s)", err, cerr)
+		}
+
+		return nil, err
+	}
+
+	if err = p.Cache.Put(p.Source, model); err != nil {
+		return nil, err
+	}
+
+	return model, nil
+}
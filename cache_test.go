@@ -0,0 +1,229 @@
+package validator
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/chop-dbhi/data-models-service/client"
+)
+
+// flakyProvider fails a set number of times before delegating.
+type flakyProvider struct {
+	SchemaProvider
+	failures int
+	calls    int
+}
+
+func (p *flakyProvider) ModelRevisions(name string) (*client.Models, error) {
+	p.calls++
+
+	if p.calls <= p.failures {
+		return nil, &ServiceError{URL: "test", StatusCode: 503}
+	}
+
+	return p.SchemaProvider.ModelRevisions(name)
+}
+
+func (p *flakyProvider) ModelRevision(name, version string) (*client.Model, error) {
+	p.calls++
+
+	if p.calls <= p.failures {
+		return nil, fmt.Errorf("error decoding model: %w", json.Unmarshal([]byte("<html>"), new(interface{})))
+	}
+
+	return p.SchemaProvider.ModelRevision(name, version)
+}
+
+func TestCachedProvider(t *testing.T) {
+	fp := &flakyProvider{
+		SchemaProvider: &DirProvider{Dir: writeModelDir(t)},
+		failures:       2,
+	}
+
+	p := &CachedProvider{
+		Provider: fp,
+		Cache:    &SchemaCache{Dir: t.TempDir()},
+		Source:   "test",
+		Retries:  2,
+	}
+
+	// Succeeds on the third attempt.
+	if _, err := p.ModelRevisions("pedsnet"); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if fp.calls != 3 {
+		t.Errorf("expected 3 calls, got %d", fp.calls)
+	}
+
+	// Pinned revisions are served from the cache.
+	fp.calls = 0
+
+	model, err := p.ModelRevision("pedsnet", "2.0.0")
+
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if fp.calls != 0 {
+		t.Errorf("expected cached revision, got %d calls", fp.calls)
+	}
+
+	f := model.Tables.Get("visit_occurrence").Fields.Get("person_id")
+
+	if f.Table == nil || f.References == nil || f.References.Field.Name != "person_id" {
+		t.Errorf("expected cached model to be linked")
+	}
+
+	// Fall back to the cache when the provider keeps failing.
+	fp.calls = 0
+	fp.failures = 10
+	p.Refresh = true
+
+	if _, err = p.ModelRevision("pedsnet", "2.0.0"); err != nil {
+		t.Errorf("expected cached revision, got error: %s", err)
+	}
+
+	if _, err = p.ModelRevisions("pedsnet"); err != nil {
+		t.Errorf("expected cached revisions, got error: %s", err)
+	}
+
+	entries, err := p.Cache.Entries()
+
+	if err != nil || len(entries) != 1 {
+		t.Fatalf("expected 1 entry, got %d (%v)", len(entries), err)
+	}
+
+	if err = p.Cache.Clear("", "pedsnet"); err != nil {
+		t.Fatal(err)
+	}
+
+	if entries, _ = p.Cache.Entries(); len(entries) != 0 {
+		t.Errorf("expected empty cache, got %d entries", len(entries))
+	}
+}
+
+func TestCachedProviderPermanentErrors(t *testing.T) {
+	var requests int
+
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		http.NotFound(w, r)
+	}))
+
+	defer s.Close()
+
+	c, err := client.New(s.URL)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := &CachedProvider{
+		Provider: &ServiceProvider{c},
+		Cache:    &SchemaCache{Dir: t.TempDir()},
+		Source:   s.URL,
+		Retries:  3,
+		Backoff:  time.Millisecond,
+	}
+
+	// A missing revision is reported without retrying.
+	_, err = p.ModelRevision("pedsnet", "9.9.9")
+
+	var serr *ServiceError
+
+	if !errors.As(err, &serr) || serr.StatusCode != 404 {
+		t.Errorf("expected not found error, got %v", err)
+	}
+
+	if requests != 1 {
+		t.Errorf("expected 1 request, got %d", requests)
+	}
+
+	// Errors other than those of the service are not retried either.
+	fp := &flakyProvider{SchemaProvider: &DirProvider{Dir: writeModelDir(t)}}
+	p.Provider = fp
+
+	if _, err = p.ModelRevision("pedsnet", "9.9.9"); err == nil {
+		t.Error("expected error for unknown version")
+	}
+
+	if fp.calls != 1 {
+		t.Errorf("expected 1 call, got %d", fp.calls)
+	}
+}
+
+// listingProvider lists revisions without their schema like the service.
+type listingProvider struct {
+	SchemaProvider
+}
+
+func (p *listingProvider) ModelRevisions(name string) (*client.Models, error) {
+	revisions, err := p.SchemaProvider.ModelRevisions(name)
+
+	if err != nil {
+		return nil, err
+	}
+
+	models := new(client.Models)
+
+	for _, m := range revisions.List() {
+		c := *m
+		c.Schema = nil
+		models.Add(&c)
+	}
+
+	return models, nil
+}
+
+func TestCachedProviderSchema(t *testing.T) {
+	p := &CachedProvider{
+		Provider: &listingProvider{&DirProvider{Dir: writeModelDir(t)}},
+		Cache:    &SchemaCache{Dir: t.TempDir()},
+		Source:   "test",
+	}
+
+	if _, err := p.ModelRevisions("pedsnet"); err != nil {
+		t.Fatal(err)
+	}
+
+	// The listed revision is not cached in place of the full revision.
+	for i := 0; i < 2; i++ {
+		model, err := p.ModelRevision("pedsnet", "2.0.0")
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		keys := TableKeys(model, "person")
+
+		if len(keys) != 1 {
+			t.Fatalf("[%d] expected the primary key, got %v", i, keys)
+		}
+
+		v := New(bytes.NewBufferString("person_id,birth_date\n1,\n1,\n"), model.Tables.Get("person"), nil)
+		v.Keys = NewKeyChecker(keys)
+
+		if err = v.Init(); err != nil {
+			t.Fatal(err)
+		}
+
+		if err = v.Run(); err != nil {
+			t.Fatal(err)
+		}
+
+		if err = v.Keys.Close(v.Result()); err != nil {
+			t.Fatal(err)
+		}
+
+		if errs := v.Result().LineErrors()[ErrDuplicatePrimaryKey]; len(errs) != 1 || errs[0].Line != 3 {
+			t.Errorf("[%d] expected primary key error on line 3, got %v", i, errs)
+		}
+	}
+}
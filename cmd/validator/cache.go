@@ -0,0 +1,161 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	dms "github.com/chop-dbhi/data-models-service/client"
+	validator "github.com/chop-dbhi/data-models-validator"
+	"github.com/olekukonko/tablewriter"
+)
+
+var cacheUsage = `Usage:
+
+  data-models-validator cache list [-cache-dir <dir>]
+
+  data-models-validator cache clear [-cache-dir <dir>]
+                                    [-service <service>]
+                                    [-model <model>]
+
+  data-models-validator cache prefetch -model <model>
+                                       [-version <version>]
+                                       [-cache-dir <dir>]
+                                       [-service <service>]
+
+Manages the on-disk cache of model revisions fetched from the data models
+service.
+
+  list      Lists the cached model revisions.
+  clear     Removes cached model revisions. Without options the whole cache
+            is cleared.
+  prefetch  Fetches and caches a model revision. If no version is specified,
+            all revisions of the model are cached.
+`
+
+func cacheCommand(args []string) {
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		fmt.Println(cacheUsage)
+		os.Exit(1)
+	}
+
+	var (
+		service   string
+		cacheDir  string
+		modelName string
+		version   string
+	)
+
+	cmd := args[0]
+
+	fs := flag.NewFlagSet("cache "+cmd, flag.ExitOnError)
+
+	fs.Usage = func() {
+		fmt.Println(cacheUsage)
+	}
+
+	fs.StringVar(&cacheDir, "cache-dir", validator.DefaultCacheDir(), "The directory schema information is cached in.")
+	fs.StringVar(&service, "service", "", "The data models service the revisions were fetched from.")
+	fs.StringVar(&modelName, "model", "", "The model.")
+	fs.StringVar(&version, "version", "", "The version of the model.")
+
+	fs.Parse(args[1:])
+
+	cache := &validator.SchemaCache{Dir: cacheDir}
+
+	switch cmd {
+	case "list":
+		entries, err := cache.Entries()
+
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+
+		if len(entries) == 0 {
+			fmt.Printf("* No cached revisions in '%s'.\n", cacheDir)
+			return
+		}
+
+		tw := tablewriter.NewWriter(os.Stdout)
+
+		tw.SetHeader([]string{
+			"service",
+			"model",
+			"version",
+			"tables",
+			"fetched",
+		})
+
+		for _, e := range entries {
+			tw.Append([]string{
+				e.Source,
+				e.Name,
+				e.Version,
+				fmt.Sprint(e.Model.Tables.Len()),
+				e.Fetched.Format(validator.DatetimeLayout),
+			})
+		}
+
+		tw.Render()
+
+	case "clear":
+		if err := cache.Clear(service, modelName); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+
+		fmt.Printf("* Cleared cached revisions in '%s'.\n", cacheDir)
+
+	case "prefetch":
+		if modelName == "" {
+			fmt.Println("A model must be specified.")
+			os.Exit(1)
+		}
+
+		if service == "" {
+			service = dms.DefaultServiceURL
+		}
+
+		provider, err := newProvider(service, "", cacheDir, true)
+
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+
+		if version != "" {
+			if _, err = provider.ModelRevision(modelName, version); err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+
+			fmt.Printf("* Cached '%s/%s'.\n", modelName, version)
+			return
+		}
+
+		revisions, err := provider.ModelRevisions(modelName)
+
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+
+		// Listed revisions do not include the schema, so each revision is
+		// fetched in full.
+		for _, m := range revisions.List() {
+			if _, err = provider.ModelRevision(m.Name, m.Version); err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+
+			fmt.Printf("* Cached '%s/%s'.\n", m.Name, m.Version)
+		}
+
+	default:
+		fmt.Printf("Unknown cache command '%s'.\n", cmd)
+		fmt.Println(cacheUsage)
+		os.Exit(1)
+	}
+}
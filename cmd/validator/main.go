@@ -7,8 +7,10 @@ import (
 	"math/rand"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 	"text/template"
+	"time"
 
 	dms "github.com/chop-dbhi/data-models-service/client"
 	validator "github.com/chop-dbhi/data-models-validator"
@@ -22,19 +24,163 @@ Usage:
   data-models-validator [-model <model>]
                         [-version <version>]
                         [-delim <delimiter>]
+                        [-quote <quote>]
+                        [-escape ( double | backslash )]
+                        [-quoting ( minimal | all-non-empty | all )]
+                        [-null-tokens <tokens>] [-empty-strings]
+                        [-lenient] [-enforce-order]
+                        [-mapping <file>] [-write-mapping <file>]
+                        [-table-rules <file>] [-table-rule <regex>=<table>]...
+                        [-strip-prefix <prefixes>] [-strip-suffix <suffixes>]
+                        [-no-header [-columns <columns>]]
+                        [-date-format <layout>]... [-datetime-format <layout>]...
+                        [-field-format <field>=<layout>]... [-timezone <policy>]
+                        [-time-format <layout>]...
+                        [-true-values <literals>] [-false-values <literals>]
+                        [-strict] [-all-errors] [-rules <file>]
+                        [-skip-keys] [-skip-refs]
+                        [-key-memory <MB>] [-spill-dir <dir>]
                         [-compr <compression>]
-                        [-service <service>]
-                        ( <file>[:<table>]... | [:<table>] )
+                        [-encoding <encoding>]
+                        [-max-record-size <bytes>]
+                        [-service <service> | -schema-dir <dir>]
+                        [-cache-dir <dir>] [-refresh]
+                        ( <input>[:<table>]... | [:<table>] )
+
+  data-models-validator cache ( list | clear | prefetch ) [<options>]
+
+  data-models-validator rules lint [<options>] [<file>]
 
 The Data Models Validator reads a file containing data and checks it against
 the data model's schema. Input files or stream are delimited files (such as CSV)
-and optionally compressed using gzip or bzip2.
+and optionally compressed using gzip, bzip2, xz, zstd or lz4. The compression
+is detected from the data unless specified with -compr. Zip and tar archives
+(optionally compressed) are read as a set of inputs. Each file in the archive
+is validated against the table named after the file unless a table is given.
 
 One or more existing input files can be explicitly passed otherwise STDIN
 will be read. Each input can be optionally annotated with an explicit table name
 to be validated against. If not specified, the file name will be used to
 determine which table the file corresponds to.
 
+An input may also be a directory or a glob pattern, in which case every file
+it contains or matches is validated. Files of the same table, such as the
+split parts person_001.csv.gz and person_002.csv.gz, are reported together
+once all inputs are read, even if they are in different archives. The tables
+of the model absent from the delivery and the files that matched no table are
+listed at the end.
+
+File names are matched to tables case-insensitively without their directory
+and extensions. The -strip-prefix and -strip-suffix options are comma-separated
+lists of prefixes and suffixes, such as a site name, removed from file names
+before matching. The -table-rule option maps file names matching a regular
+expression to a table which may refer to submatches, for example
+'^SITE_(.*)_\d{8}$=$1'. The -table-rules option reads the same rules from a
+CSV file with the columns type (prefix, suffix or regex), pattern and table.
+Rules take precedence over the file name, followed by the name stripped of
+prefixes and suffixes and finally the name without a split part number. A file
+matching several tables at the same level is reported as ambiguous.
+
+Model definitions are fetched from the data models service by default. The
+-schema-dir option reads them from a local checkout of the data models
+repository instead, which does not require network access.
+
+Model revisions fetched from the service are cached on disk. A cached revision
+is used when a specific -version is requested or when the service is not
+available. The -refresh option fetches a fresh copy of the revision. The cache
+subcommand lists, clears or prefetches cached revisions; run it with -help for
+details.
+
+The -delim option may be more than one character and supports escape sequences
+such as \t for tab-delimited files. Quotes within quoted values are escaped by
+doubling them by default; use -escape backslash for files escaping them with a
+backslash instead.
+
+The -quoting option sets which values must be quoted: minimal only requires
+values containing special characters to be quoted, all-non-empty requires all
+non-empty values to be quoted and all requires every value to be quoted.
+Values violating the policy are reported by row with the column and field.
+
+Unquoted empty values are null. The -null-tokens option is a comma-separated
+list of additional unquoted values denoting null, such as NULL,\N,NA. Quoted
+values are never null tokens. Quoted empty values are treated as null unless
+-empty-strings is set, in which case they are empty strings in string fields
+and satisfy required fields.
+
+Input that is not encoded in UTF-8 is transcoded from the encoding given by the
+-encoding option. If set to auto, the encoding is detected from the byte order
+mark or a sample of the input. A finding is reported if the content does not
+match the declared encoding.
+
+By default a file is skipped if its header does not contain the fields of the
+table. With -lenient, the header problems are reported and the columns that
+match fields are still validated. Unknown columns are ignored and missing
+required columns are reported once for the file. With -enforce-order, the
+columns must also be in the order the model declares its fields in, as
+required by positional bulk loaders. Duplicate columns are always reported.
+
+The -mapping option reads a CSV file with the columns table, column and field
+that maps columns in the header to fields before the header is checked. An
+empty table applies the mapping to all tables. For unknown columns, similarly
+named fields are suggested. The -write-mapping option writes a starter mapping
+file for the inputs with the matched or suggested field of each column.
+
+With -no-header, the first line of the input is data rather than a header and
+line numbers count from the first data row. The columns are given in order by
+the -columns option, by the rows of the table in the mapping file or are
+otherwise assumed to follow the order the model declares its fields in: the
+row order of fields.csv with -schema-dir or the order the service lists them.
+
+Dates must be formatted as 2006-01-02 and datetimes as 2006-01-02 15:04:05 or
+an ISO 8601 variant with a T separator, fractional seconds and a Z or UTC
+offset. Datetimes are also valid dates. The -date-format and -datetime-format
+options replace the accepted layouts for all fields of the model and may be
+repeated. Layouts are Go time layouts written for the reference time
+Mon Jan 2 15:04:05 2006 in UTC-7, such as 01/02/2006, or iso8601 for the ISO
+8601 variants. The -field-format option sets the layouts of a single field as
+<table>.<field>=<layout> or <field>=<layout> for the field in every table.
+The -timezone option requires datetimes to have a timezone (required), to not
+have one (none) or to be in UTC if they have one (utc). Dates and times are not
+checked against the policy. The layouts that were tried are reported with
+values that do not match. Times are formatted as 15:04:05 or 15:04 by default
+and the -time-format option replaces the layouts.
+
+Boolean fields accept true, t, yes, y and 1 or false, f, no, n and 0 in any
+case. The -true-values and -false-values options are comma-separated lists
+replacing the literals. Type names such as int, smallint, numeric, bool or
+timestamp are treated as aliases of the types of the model. Fields of types
+the validator does not know are only checked for their encoding and required
+values; with -strict they are reported as table-level errors.
+
+Only the first problem of a value is reported by default. With -all-errors,
+every check of a value is run, so a value that is not valid UTF-8 and too long
+is reported for both. Checks that depend on the value parsing as the type of
+the field, such as the bounds of rules, are skipped only if it does not parse.
+
+The -rules option reads a YAML or JSON file of rules constraining the values
+of fields beyond the schema: a regular expression pattern, numeric min and max,
+a list of allowed values, a date range with min_date and max_date, or a value
+required if another field has one of the given values (required_if). Row rules
+(row_rules) compare two fields of a record (left, op and right) or require one
+of several fields to have a value (require_any). Each rule is reported with its
+own code and message. With -schema-dir, a rules.yaml, rules.yml or rules.json
+file in the directory of the revision is used unless -rules is given. The rules
+lint subcommand checks the tables and fields of a rule file exist in the model;
+run it with -help for details.
+
+The primary key and unique constraints of the model are checked across all
+files of a table, such as split parts. Each repeated key is reported with the
+line it first occurred on. Keys with a null value are not checked. Up to
+-key-memory megabytes of keys are held in memory; beyond that they are spilled
+to files in -spill-dir. The -skip-keys option disables the checks.
+
+The foreign keys of the model are checked across all inputs once they are
+read. For each foreign key of a table in the inputs, the number of values that
+do not exist in the referenced field and samples of them are reported. Foreign
+keys whose referenced table or fields were not read are reported as not
+checked. The values are held in memory and spilled to disk like keys. The
+-skip-refs option disables the checks.
+
 The validator returns an exit status of 0 if no errors are found and nonzero
 otherwise.
 
@@ -49,7 +195,25 @@ Examples:
   data-models-validator -model omop -version 5.0.0 foo.csv:person
 
   # Validate the STDIN stream denoting it is tab-delimited and gzipped.
-  data-models-validator -model omop -version 5.0.0 -delim $'\t' -compr gzip
+  data-models-validator -model omop -version 5.0.0 -delim '\t' -compr gzip
+
+  # Validate every file in the delivery directory.
+  data-models-validator -model omop -version 5.0.0 ./delivery
+
+  # Validate each file in an archive, such as person.csv and death.csv.
+  data-models-validator -model omop -version 5.0.0 extract.tar.gz
+
+  # Validate a pipe-delimited file where every value is quoted.
+  data-models-validator -model omop -version 5.0.0 -delim '|' -quoting all-non-empty person.psv:person
+
+  # Validate a file exported from Excel, detecting its encoding.
+  data-models-validator -model omop -version 5.0.0 -encoding auto person.csv
+
+  # Prefetch the OMOP v5 revision before running nightly jobs.
+  data-models-validator cache prefetch -model omop -version 5.0.0
+
+  # Validate person.csv using a local checkout of the data models repository.
+  data-models-validator -model omop -version 5.0.0 -schema-dir ./data-models person.csv
 `
 
 func init() {
@@ -71,20 +235,98 @@ func init() {
 const sampleSize = 5
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "cache" {
+		cacheCommand(os.Args[2:])
+		return
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "rules" {
+		rulesCommand(os.Args[2:])
+		return
+	}
+
 	var (
 		service   string
+		schemaDir string
+		cacheDir  string
+		refresh   bool
 		modelName string
 		version   string
 		delim     string
+		quote     string
+		escape    string
+		quoting   string
+		nulls     string
+		empty     bool
+		lenient   bool
+		order     bool
+		mapFile   string
+		writeMap  string
+		noHeader  bool
+		columns   string
 		compr     string
+		encoding  string
+		maxRecord int
+		rulesFile string
+		prefixes  string
+		suffixes  string
+		patterns  listFlags
+		dates     listFlags
+		datetimes listFlags
+		fieldFmts listFlags
+		timezone  string
+		times     listFlags
+		trues     string
+		falses    string
+		strict    bool
+		rulesPath string
+		allErrors bool
+		skipKeys  bool
+		skipRefs  bool
+		keyMemory int
+		spillDir  string
 	)
 
 	flag.StringVar(&modelName, "model", "", "The model to validate against. Required.")
 	flag.StringVar(&version, "version", "", "The specific version of the model to validate against. Defaults to the latest version of the model.")
 	flag.StringVar(&service, "service", dms.DefaultServiceURL, "The data models service to use for fetching schema information.")
-
-	flag.StringVar(&delim, "delim", ",", "The delimiter used in the input files or stream.")
-	flag.StringVar(&compr, "compr", "", "The compression method used on the input files or stream. If ommitted the file extension will be used to infer the compression method: .gz, .gzip, .bzip2, .bz2.")
+	flag.StringVar(&schemaDir, "schema-dir", "", "A local checkout of the data models repository to read schema information from instead of the service.")
+	flag.StringVar(&cacheDir, "cache-dir", validator.DefaultCacheDir(), "The directory schema information fetched from the service is cached in. An empty value disables the cache.")
+	flag.BoolVar(&refresh, "refresh", false, "Fetch schema information from the service even if it is cached.")
+
+	flag.StringVar(&delim, "delim", ",", "The delimiter used in the input files or stream. Escape sequences such as \\t are supported.")
+	flag.StringVar(&quote, "quote", `"`, "The character used to quote values.")
+	flag.StringVar(&escape, "escape", "double", "How quotes are escaped within quoted values: double or backslash.")
+	flag.StringVar(&quoting, "quoting", "minimal", "Which values must be quoted: minimal, all-non-empty or all.")
+	flag.StringVar(&nulls, "null-tokens", "", "A comma-separated list of unquoted values denoting null in addition to the empty value, such as NULL,\\N,NA.")
+	flag.BoolVar(&empty, "empty-strings", false, "Treat quoted empty values in string fields as empty strings rather than null.")
+	flag.BoolVar(&lenient, "lenient", false, "Validate the columns that match fields even if the header does not match the table.")
+	flag.BoolVar(&order, "enforce-order", false, "Require the columns in the header to be in the order of the fields.")
+	flag.StringVar(&mapFile, "mapping", "", "A CSV file mapping columns in the header to fields.")
+	flag.StringVar(&writeMap, "write-mapping", "", "Write a starter mapping file for the inputs.")
+	flag.BoolVar(&noHeader, "no-header", false, "The inputs do not have a header.")
+	flag.StringVar(&columns, "columns", "", "A comma-separated list of the columns of inputs without a header.")
+	flag.StringVar(&rulesFile, "table-rules", "", "A CSV file of rules mapping file names to tables.")
+	flag.StringVar(&prefixes, "strip-prefix", "", "A comma-separated list of prefixes stripped from file names when matching them to tables.")
+	flag.StringVar(&suffixes, "strip-suffix", "", "A comma-separated list of suffixes stripped from file names when matching them to tables.")
+	flag.Var(&patterns, "table-rule", "A rule <regex>=<table> mapping file names matching the regex to the table, which may refer to submatches such as $1. May be repeated.")
+	flag.Var(&dates, "date-format", "A layout accepted for date fields, such as 01/02/2006. May be repeated. Replaces the default layouts.")
+	flag.Var(&datetimes, "datetime-format", "A layout accepted for datetime fields, such as 01/02/2006 15:04 or iso8601. May be repeated. Replaces the default layouts.")
+	flag.Var(&fieldFmts, "field-format", "A layout <table>.<field>=<layout> or <field>=<layout> accepted for the field. May be repeated.")
+	flag.Var(&times, "time-format", "A layout accepted for time fields, such as 3:04PM. May be repeated. Replaces the default layouts.")
+	flag.StringVar(&trues, "true-values", "", "A comma-separated list of the literals accepted as true in boolean fields. Defaults to true,t,yes,y,1.")
+	flag.StringVar(&falses, "false-values", "", "A comma-separated list of the literals accepted as false in boolean fields. Defaults to false,f,no,n,0.")
+	flag.StringVar(&rulesPath, "rules", "", "A YAML or JSON file of rules constraining the values of fields.")
+	flag.BoolVar(&strict, "strict", false, "Report fields of types the validator does not know as errors.")
+	flag.BoolVar(&allErrors, "all-errors", false, "Report every failing check of a value rather than only the first.")
+	flag.BoolVar(&skipKeys, "skip-keys", false, "Do not check the primary key and unique constraints.")
+	flag.BoolVar(&skipRefs, "skip-refs", false, "Do not check the foreign keys across the inputs.")
+	flag.IntVar(&keyMemory, "key-memory", validator.DefaultKeyMemory>>20, "The megabytes of keys held in memory when checking primary key, unique and foreign key constraints before they are spilled to disk.")
+	flag.StringVar(&spillDir, "spill-dir", "", "The directory keys are spilled to. Defaults to the temporary directory.")
+	flag.StringVar(&timezone, "timezone", "any", "Whether datetimes may have a timezone: any, required, none or utc.")
+	flag.IntVar(&maxRecord, "max-record-size", 16<<20, "The maximum size of a record in bytes. Larger records are reported and skipped. Zero means records are unbounded.")
+	flag.StringVar(&encoding, "encoding", validator.EncodingUTF8, "The character encoding of the input files or stream: utf-8, utf-16, utf-16le, utf-16be, windows-1252, iso-8859-1 or auto to detect it.")
+	flag.StringVar(&compr, "compr", "", "The compression method or archive format of the input files or stream: gzip, bzip2, xz, zstd, lz4, zip or tar. If ommitted it is detected from the data.")
 
 	flag.Parse()
 
@@ -101,107 +343,207 @@ func main() {
 		os.Exit(1)
 	}
 
-	// Initialize data models client for service.
-	c, err := dms.New(service)
+	dialect, err := parseDialect(delim, quote, escape, quoting)
 
 	if err != nil {
 		fmt.Println(err)
 		os.Exit(1)
 	}
 
-	if err = c.Ping(); err != nil {
-		fmt.Println(err)
-		os.Exit(1)
+	var nullTokens, columnList, trueValues, falseValues []string
+
+	if trues != "" {
+		trueValues = strings.Split(trues, ",")
 	}
 
-	revisions, err := c.ModelRevisions(modelName)
+	if falses != "" {
+		falseValues = strings.Split(falses, ",")
+	}
 
-	if err != nil {
-		fmt.Println(err)
-		os.Exit(1)
+	if nulls != "" {
+		nullTokens = strings.Split(nulls, ",")
 	}
 
-	var model *dms.Model
+	if columns != "" {
+		columnList = strings.Split(columns, ",")
+	}
 
-	// Get the latest version.
-	if version == "" {
-		model = revisions.Latest()
-	} else {
+	var mapping validator.HeaderMapping
 
-		var (
-			versions []string
-			_model   *dms.Model
-		)
+	if mapFile != "" {
+		if mapping, err = validator.OpenHeaderMapping(mapFile); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	}
 
-		for _, _model = range revisions.List() {
-			if _model.Version == version {
-				model = _model
-				break
-			}
+	rules := &validator.TableRules{}
 
-			versions = append(versions, _model.Version)
+	if rulesFile != "" {
+		if rules, err = validator.OpenTableRules(rulesFile); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
 		}
+	}
+
+	if prefixes != "" {
+		rules.Prefixes = append(rules.Prefixes, strings.Split(prefixes, ",")...)
+	}
 
-		if model == nil {
-			fmt.Printf("Invalid version for '%s'. Choose from: %s\n", modelName, strings.Join(versions, ", "))
+	if suffixes != "" {
+		rules.Suffixes = append(rules.Suffixes, strings.Split(suffixes, ",")...)
+	}
+
+	for _, p := range patterns {
+		i := strings.LastIndex(p, "=")
+
+		if i < 0 {
+			fmt.Printf("Table rule '%s' must be of the form <regex>=<table>.\n", p)
 			os.Exit(1)
 		}
+
+		rule, err := validator.NewTableRule(p[:i], p[i+1:])
+
+		if err != nil {
+			fmt.Printf("Invalid table rule '%s': %s\n", p, err)
+			os.Exit(1)
+		}
+
+		rules.Rules = append(rules.Rules, rule)
+	}
+
+	formats := &validator.DateFormats{
+		Date:     validator.ExpandLayouts(dates),
+		Datetime: validator.ExpandLayouts(datetimes),
+		Time:     times,
+		Fields:   make(map[string][]string),
+	}
+
+	if formats.Timezone, err = validator.ParseTimezonePolicy(timezone); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	for _, f := range fieldFmts {
+		i := strings.Index(f, "=")
+
+		if i < 0 {
+			fmt.Printf("Field format '%s' must be of the form <field>=<layout>.\n", f)
+			os.Exit(1)
+		}
+
+		key := strings.ToLower(f[:i])
+		formats.Fields[key] = append(formats.Fields[key], validator.ExpandLayouts([]string{f[i+1:]})...)
+	}
+
+	provider, err := newProvider(service, schemaDir, cacheDir, refresh)
+
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
 	}
 
+	model := loadModel(provider, modelName, version)
+
 	fmt.Printf("Validating against model '%s/%s'\n", model.Name, model.Version)
 
-	var (
-		hasErrors bool
-		tableName string
-		table     *dms.Table
-	)
+	// A rule file kept with the definition files of a local revision is used
+	// unless one is given.
+	if rulesPath == "" {
+		if rulesPath = validator.FindRuleFile(model.Path); rulesPath != "" {
+			fmt.Printf("* Using the rules in '%s'.\n", rulesPath)
+		}
+	}
 
-	for _, name := range inputs {
-		// The file name may have a suffix containing the table name, name[:table].
-		// The fallback is to use the file name without the extension.
-		toks := strings.SplitN(name, ":", 2)
+	if rulesPath != "" {
+		rs, err := validator.OpenRuleSet(rulesPath)
 
-		if len(toks) == 2 {
-			name = toks[0]
-			tableName = toks[1]
-		} else {
-			name = toks[0]
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
 
-			toks = strings.SplitN(filepath.Base(name), ".", 2)
-			tableName = toks[0]
+		for _, e := range rs.Errors() {
+			if err = validator.RegisterError(e); err != nil {
+				fmt.Printf("%s: %s\n", rulesPath, err)
+				os.Exit(1)
+			}
 		}
 
-		if table = model.Tables.Get(tableName); table == nil {
-			fmt.Printf("* Unknown table '%s'.\nChoices are: %s\n", tableName, strings.Join(model.Tables.Names(), ", "))
-			continue
+		for _, p := range rs.Lint(model) {
+			fmt.Printf("* Rule problem in '%s': %s\n", rulesPath, p)
 		}
 
-		fmt.Printf("* Evaluating '%s' table in '%s'...\n", tableName, name)
+		validator.AddPlanHook(rs.PlanHook())
+	}
 
-		// Open the reader.
-		reader, err := validator.Open(name, compr)
+	var (
+		hasErrors bool
+		starter   validator.HeaderMapping
+		refs      *validator.ReferenceChecker
+	)
 
-		if err != nil {
-			fmt.Printf("* Could not open file: %s\n", err)
-			continue
-		}
+	if fks := validator.ModelReferences(model); !skipRefs && len(fks) > 0 {
+		refs = validator.NewReferenceChecker(fks)
+		refs.MaxMemory = keyMemory << 20
+		refs.Dir = spillDir
+	}
 
-		v := validator.New(reader, table)
+	// Checks the input against the table. The header problems are output
+	// immediately. It returns the result and whether the data was read.
+	check := func(reader *validator.Reader, table *dms.Table, keys *validator.KeyChecker, path string) (*validator.Result, bool) {
+		v := validator.New(reader, table, dialect)
+		v.Keys = keys
+		v.Refs = refs
+		v.File = path
+		v.MaxRecordSize = maxRecord
+		v.NullTokens = nullTokens
+		v.EmptyStrings = empty
+		v.Lenient = lenient
+		v.EnforceOrder = order
+		v.Mapping = mapping
+		v.NoHeader = noHeader
+		v.Columns = columnList
+		v.DateFormats = formats
+		v.TrueValues = trueValues
+		v.FalseValues = falseValues
+		v.Strict = strict
+		v.AllErrors = allErrors
+
+		err := v.Init()
+
+		starter = append(starter, v.StarterMapping()...)
 
-		if err = v.Init(); err != nil {
+		if err != nil {
 			fmt.Printf("* Problem reading CSV header: %s\n", err)
-			reader.Close()
-			continue
+
+			if verr, ok := err.(*validator.ValidationError); ok {
+				renderSuggestions(verr)
+			}
+
+			hasErrors = true
+
+			return v.Result(), false
 		}
 
 		if err = v.Run(); err != nil {
 			fmt.Printf("* Problem reading CSV data: %s\n", err)
 		}
 
-		reader.Close()
+		return v.Result(), true
+	}
+
+	// Outputs the result of the table.
+	render := func(result *validator.Result, table *dms.Table, read bool) {
+		terrs := result.TableErrors()
 
-		// Build the result.
-		result := v.Result()
+		if renderTableErrors(result) {
+			hasErrors = true
+		}
+
+		if !read {
+			return
+		}
 
 		lerrs := result.LineErrors()
 
@@ -227,9 +569,9 @@ func main() {
 				ve := verrs[0]
 
 				if ve.Context != nil {
-					example = fmt.Sprintf("line %d: `%v` %v", ve.Line, ve.Value, ve.Context)
+					example = fmt.Sprintf("%s: `%v` %v", errLocation(ve), ve.Value, ve.Context)
 				} else {
-					example = fmt.Sprintf("line %d: `%v`", ve.Line, ve.Value)
+					example = fmt.Sprintf("%s: `%v`", errLocation(ve), ve.Value)
 				}
 
 				errsteps := errLineSteps(verrs)
@@ -267,7 +609,7 @@ func main() {
 		var nerrs int
 
 		// Output the error occurrence per field.
-		for _, f := range v.Header {
+		for _, f := range table.Fields.Names() {
 			errmap := result.FieldErrors(f)
 
 			if len(errmap) == 0 {
@@ -300,9 +642,9 @@ func main() {
 
 				for i, ve := range sample {
 					if ve.Context != nil {
-						sstrings[i] = fmt.Sprintf("line %d: `%s` %s", ve.Line, ve.Value, ve.Context)
+						sstrings[i] = fmt.Sprintf("%s: `%s` %s", errLocation(ve), ve.Value, ve.Context)
 					} else {
-						sstrings[i] = fmt.Sprintf("line %d: `%s`", ve.Line, ve.Value)
+						sstrings[i] = fmt.Sprintf("%s: `%s`", errLocation(ve), ve.Value)
 					}
 				}
 
@@ -329,52 +671,543 @@ func main() {
 			hasErrors = true
 			fmt.Println("* Field-level issues were found.")
 			tw.Render()
-		} else if len(lerrs) == 0 {
+		} else if len(lerrs) == 0 && len(terrs) == 0 {
 			fmt.Println("* Everything looks good!")
 		}
 	}
 
+	var (
+		files     []*tableInput
+		delivered = make(map[string]bool)
+		unmatched []string
+		delivery  bool
+	)
+
+	for _, input := range inputs {
+		expanded, err := validator.ExpandInputs([]string{input})
+
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+
+		if len(expanded) != 1 || expanded[0] != input {
+			delivery = true
+		}
+
+		if len(expanded) == 0 {
+			fmt.Printf("* No files found for '%s'.\n", input)
+		}
+
+		for _, file := range expanded {
+			// The file name may have a suffix containing the table name, name[:table].
+			// The fallback is to match the file name against the tables.
+			toks := strings.SplitN(file, ":", 2)
+			in := &tableInput{name: toks[0]}
+
+			if len(toks) == 2 {
+				in.table = toks[1]
+			}
+
+			files = append(files, in)
+		}
+	}
+
+	// Files of the same table, such as split parts, are reported together
+	// even if they are in different archives. The order of the reports is
+	// the order the tables first appear.
+	var (
+		tables  []*dms.Table
+		results = make(map[*dms.Table][]*partResult)
+		keys    = make(map[*dms.Table]*validator.KeyChecker)
+	)
+
+	for _, in := range files {
+		name, tableName := in.name, in.table
+
+		// Archives contain several inputs. Their table is inferred from the
+		// name of the file in the archive.
+		validator.ReadInputs(name, compr, encoding, func(reader *validator.Reader, err error) error {
+			path := name
+
+			if name == "" {
+				path = "STDIN"
+			}
+
+			if reader.Entry != "" {
+				path = fmt.Sprintf("%s:%s", name, reader.Entry)
+			}
+
+			if err != nil {
+				fmt.Printf("* Could not open file '%s': %s\n", path, err)
+				return nil
+			}
+
+			var table *dms.Table
+
+			if tableName != "" {
+				if table = model.Tables.Get(tableName); table == nil {
+					fmt.Printf("* Unknown table '%s' for '%s'.\nChoices are: %s\n", tableName, path, strings.Join(model.Tables.Names(), ", "))
+					return nil
+				}
+			} else {
+				var err error
+
+				if reader.Entry != "" {
+					table, err = rules.Match(model.Tables, reader.Entry)
+				} else if name != "" {
+					table, err = rules.Match(model.Tables, name)
+				}
+
+				if aerr, ok := err.(*validator.AmbiguousTableError); ok {
+					fmt.Printf("* Ambiguous table for '%s', it matches: %s\nAdd :<table> to the input to choose one.\n", path, strings.Join(aerr.Tables, ", "))
+					unmatched = append(unmatched, path)
+					return nil
+				}
+
+				if table == nil {
+					fmt.Printf("* No table matches '%s'.\nChoices are: %s\n", path, strings.Join(model.Tables.Names(), ", "))
+					unmatched = append(unmatched, path)
+					return nil
+				}
+			}
+
+			fmt.Printf("* Evaluating '%s' table in '%s'...\n", table.Name, path)
+
+			if reader.Compression != "" {
+				fmt.Printf("* Decompressing '%s' as %s.\n", path, reader.Compression)
+			}
+
+			if reader.Encoding != validator.EncodingUTF8 {
+				fmt.Printf("* Decoding '%s' as %s.\n", path, reader.Encoding)
+			}
+
+			if _, ok := results[table]; !ok {
+				tables = append(tables, table)
+
+				// Keys are checked across the files of the table.
+				if constraints := validator.TableKeys(model, table.Name); !skipKeys && len(constraints) > 0 {
+					k := validator.NewKeyChecker(constraints)
+					k.MaxMemory = keyMemory << 20
+					k.Dir = spillDir
+					keys[table] = k
+				}
+			}
+
+			result, read := check(reader, table, keys[table], path)
+
+			results[table] = append(results[table], &partResult{path, result, read})
+			delivered[table.Name] = true
+
+			return nil
+		})
+	}
+
+	for _, table := range tables {
+		parts := results[table]
+
+		var (
+			result *validator.Result
+			read   bool
+		)
+
+		if len(parts) == 1 {
+			fmt.Printf("* Results for '%s' table in '%s':\n", table.Name, parts[0].path)
+
+			result, read = parts[0].result, parts[0].read
+		} else {
+			fmt.Printf("* Results for '%s' table across %d files:\n", table.Name, len(parts))
+
+			result = validator.NewResult()
+
+			for _, p := range parts {
+				result.Merge(p.result, p.path)
+				read = read || p.read
+			}
+		}
+
+		if k := keys[table]; k != nil {
+			if k.Spilled() {
+				fmt.Printf("* Keys of '%s' table were spilled to disk.\n", table.Name)
+			}
+
+			if err := k.Close(result); err != nil {
+				fmt.Printf("* Problem checking keys of '%s' table: %s\n", table.Name, err)
+			}
+		}
+
+		render(result, table, read)
+	}
+
+	if refs != nil {
+		if refs.Spilled() {
+			fmt.Println("* Foreign key values were spilled to disk.")
+		}
+
+		results, err := refs.Close()
+
+		if err != nil {
+			fmt.Printf("* Problem checking foreign keys: %s\n", err)
+		} else if renderReferences(results) {
+			hasErrors = true
+		}
+	}
+
+	// Report the coverage of the model by the delivery.
+	if delivery {
+		if absent := validator.AbsentTables(model, delivered); len(absent) > 0 {
+			fmt.Printf("* Tables absent from the delivery: %s\n", strings.Join(absent, ", "))
+		}
+
+		if len(unmatched) > 0 {
+			fmt.Printf("* Files that matched no table: %s\n", strings.Join(unmatched, ", "))
+		}
+	}
+
+	if writeMap != "" {
+		if err = writeMapping(writeMap, starter); err != nil {
+			fmt.Printf("* Could not write mapping: %s\n", err)
+			os.Exit(1)
+		}
+
+		fmt.Printf("* Wrote mapping to '%s'.\n", writeMap)
+	}
+
 	if hasErrors {
 		os.Exit(1)
 	}
 }
 
-// Returns a slice of line ranges that errors have occurred on.
+// listFlags collects the values of a repeated flag.
+type listFlags []string
+
+func (f *listFlags) String() string {
+	return strings.Join(*f, ", ")
+}
+
+func (f *listFlags) Set(v string) error {
+	*f = append(*f, v)
+	return nil
+}
+
+// tableInput is an input file with the table it was explicitly given.
+type tableInput struct {
+	name  string
+	table string
+}
+
+// partResult is the result of one of the files of a table.
+type partResult struct {
+	path   string
+	result *validator.Result
+	read   bool
+}
+
+// renderSuggestions outputs the suggested fields for unknown columns.
+func renderSuggestions(verr *validator.ValidationError) {
+	suggestions, _ := verr.Context["suggestions"].(map[string][]string)
+
+	columns := make([]string, 0, len(suggestions))
+
+	for c := range suggestions {
+		columns = append(columns, c)
+	}
+
+	sort.Strings(columns)
+
+	for _, c := range columns {
+		fmt.Printf("  - Unknown column '%s', did you mean: %s?\n", c, strings.Join(suggestions[c], ", "))
+	}
+}
+
+// writeMapping writes the mapping to a file.
+func writeMapping(name string, m validator.HeaderMapping) error {
+	f, err := os.Create(name)
+
+	if err != nil {
+		return err
+	}
+
+	if err = m.Write(f); err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}
+
+// renderTableErrors outputs the errors that apply to the table as a whole.
+// It returns true if there were any errors.
+func renderTableErrors(result *validator.Result) bool {
+	terrs := result.TableErrors()
+
+	if len(terrs) == 0 {
+		return false
+	}
+
+	fmt.Println("* Table-level issues were found.")
+
+	tw := tablewriter.NewWriter(os.Stdout)
+
+	tw.SetHeader([]string{
+		"code",
+		"error",
+		"field",
+		"details",
+	})
+
+	for _, ve := range terrs {
+		var details string
+
+		if ve.Context != nil {
+			details = ve.Context.String()
+		}
+
+		tw.Append([]string{
+			fmt.Sprint(ve.Err.Code),
+			ve.Err.Description,
+			ve.Field,
+			details,
+		})
+	}
+
+	tw.Render()
+
+	return true
+}
+
+// renderReferences outputs the results of the foreign keys. It returns
+// true if orphan values were found.
+func renderReferences(results []*validator.ReferenceResult) bool {
+	if len(results) == 0 {
+		return false
+	}
+
+	var orphans bool
+
+	for _, r := range results {
+		if r.Orphans > 0 {
+			orphans = true
+		}
+	}
+
+	if orphans {
+		fmt.Println("* Foreign key issues were found.")
+	} else {
+		fmt.Println("* Foreign keys:")
+	}
+
+	tw := tablewriter.NewWriter(os.Stdout)
+
+	tw.SetHeader([]string{
+		"reference",
+		"code",
+		"status",
+		"orphans",
+		"samples",
+	})
+
+	for _, r := range results {
+		var (
+			code, status, count string
+			samples             []string
+		)
+
+		switch {
+		case !r.Checked:
+			status = fmt.Sprintf("not checked: %s", r.Reason)
+		case r.Orphans == 0:
+			status = "ok"
+		default:
+			code = fmt.Sprint(validator.ErrOrphanReference.Code)
+			status = validator.ErrOrphanReference.Description
+			count = fmt.Sprintf("%d (%d distinct)", r.Orphans, r.Values)
+
+			for _, s := range r.Samples {
+				samples = append(samples, fmt.Sprintf("%s: `%s`", errLocation(s), s.Value))
+			}
+		}
+
+		tw.Append([]string{
+			r.String(),
+			code,
+			status,
+			count,
+			strings.Join(samples, " "),
+		})
+	}
+
+	tw.Render()
+
+	return orphans
+}
+
+// errLocation returns the line the error occurred on. The record number is
+// included if it differs from the line, such as after multi-line records.
+// The file is included for results merged from several files.
+func errLocation(ve *validator.ValidationError) string {
+	location := fmt.Sprintf("line %d", ve.Line)
+
+	if ve.File != "" {
+		location = fmt.Sprintf("%s %s", filepath.Base(ve.File), location)
+	}
+
+	if ve.Record != 0 && ve.Record != ve.Line {
+		return fmt.Sprintf("%s (record %d)", location, ve.Record)
+	}
+
+	return location
+}
+
+// Returns a slice of line ranges that errors have occurred on. Ranges are
+// prefixed with the file for results merged from several files.
 func errLineSteps(errs []*validator.ValidationError) []string {
 	var (
 		start, end int
+		file       string
 		steps      []string
 	)
 
+	step := func() {
+		var prefix string
+
+		if file != "" {
+			prefix = filepath.Base(file) + ":"
+		}
+
+		// Skipped a line, log the step
+		if start == end {
+			steps = append(steps, fmt.Sprintf("%s%d", prefix, start))
+		} else {
+			steps = append(steps, fmt.Sprintf("%s%d-%d", prefix, start, end))
+		}
+	}
+
 	for _, err := range errs {
 		if start == 0 {
 			start = err.Line
 			end = start
+			file = err.File
 			continue
 		}
 
-		if err.Line == end+1 {
+		if err.Line == end+1 && err.File == file {
 			end = err.Line
 			continue
 		}
 
-		// Skipped a line, log the step
-		if start == end {
-			steps = append(steps, fmt.Sprint(start))
-		} else {
-			steps = append(steps, fmt.Sprintf("%d-%d", start, end))
-		}
+		step()
 
 		start = err.Line
 		end = err.Line
+		file = err.File
 	}
 
-	// Skipped a line, log the step
-	if start == end {
-		steps = append(steps, fmt.Sprint(start))
-	} else {
-		steps = append(steps, fmt.Sprintf("%d-%d", start, end))
-	}
+	step()
 
 	return steps
 }
+
+// parseDialect builds the CSV dialect from the command line options.
+func parseDialect(delim, quote, escape, quoting string) (*validator.Dialect, error) {
+	var err error
+
+	d := validator.DefaultDialect()
+
+	if d.Delimiter, err = validator.ParseDelimiter(delim); err != nil {
+		return nil, err
+	}
+
+	if len(quote) != 1 {
+		return nil, fmt.Errorf("quote must be a single character")
+	}
+
+	d.Quote = quote[0]
+
+	if d.Escape, err = validator.ParseEscapeStyle(escape); err != nil {
+		return nil, err
+	}
+
+	if d.Quoting, err = validator.ParseQuotingPolicy(quoting); err != nil {
+		return nil, err
+	}
+
+	return d, nil
+}
+
+// loadModel returns the revision of the model or the latest revision if no
+// version is given. It exits if the revision cannot be loaded.
+func loadModel(provider validator.SchemaProvider, name, version string) *dms.Model {
+	// Get the latest version.
+	if version == "" {
+		revisions, err := provider.ModelRevisions(name)
+
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+
+		latest := revisions.Latest()
+
+		// Listed revisions may not include the schema.
+		if latest.Schema == nil {
+			if model, err := provider.ModelRevision(name, latest.Version); err == nil {
+				return model
+			}
+		}
+
+		return latest
+	}
+
+	model, err := provider.ModelRevision(name, version)
+
+	if err == nil {
+		return model
+	}
+
+	revisions, rerr := provider.ModelRevisions(name)
+
+	if rerr != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	var versions []string
+
+	for _, m := range revisions.List() {
+		versions = append(versions, m.Version)
+	}
+
+	fmt.Printf("Invalid version for '%s'. Choose from: %s\n", name, strings.Join(versions, ", "))
+	os.Exit(1)
+
+	return nil
+}
+
+// newProvider returns the schema provider for a local checkout of the data
+// models repository or the service. Revisions fetched from the service are
+// cached unless cacheDir is empty.
+func newProvider(service, schemaDir, cacheDir string, refresh bool) (validator.SchemaProvider, error) {
+	if schemaDir != "" {
+		return &validator.DirProvider{Dir: schemaDir}, nil
+	}
+
+	if cacheDir == "" {
+		return validator.NewServiceProvider(service)
+	}
+
+	c, err := dms.New(service)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &validator.CachedProvider{
+		Provider: &validator.ServiceProvider{Client: c},
+		Cache:    &validator.SchemaCache{Dir: cacheDir},
+		Source:   service,
+		Refresh:  refresh,
+		Retries:  3,
+		Backoff:  time.Second,
+	}, nil
+}
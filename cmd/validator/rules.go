@@ -0,0 +1,124 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	dms "github.com/chop-dbhi/data-models-service/client"
+	validator "github.com/chop-dbhi/data-models-validator"
+)
+
+var rulesUsage = `Usage:
+
+  data-models-validator rules lint [-model <model>]
+                                   [-version <version>]
+                                   [-service <service> | -schema-dir <dir>]
+                                   [-cache-dir <dir>]
+                                   [<file>]
+
+Checks a rule file.
+
+  lint  Checks the rules can be read and the tables and fields they refer to
+        exist in the model revision. The model and version default to the
+        ones named in the rule file. Constraints that do not apply to the
+        type of a field, fields of row rules that cannot be compared and
+        conflicting codes are also reported. With -schema-dir and -model,
+        the file defaults to the rule file in the directory of the revision.
+`
+
+func rulesCommand(args []string) {
+	if len(args) == 0 || args[0] != "lint" {
+		fmt.Println(rulesUsage)
+		os.Exit(1)
+	}
+
+	var (
+		service   string
+		schemaDir string
+		cacheDir  string
+		modelName string
+		version   string
+	)
+
+	fs := flag.NewFlagSet("rules lint", flag.ExitOnError)
+
+	fs.Usage = func() {
+		fmt.Println(rulesUsage)
+	}
+
+	fs.StringVar(&modelName, "model", "", "The model to check the rules against.")
+	fs.StringVar(&version, "version", "", "The version of the model.")
+	fs.StringVar(&service, "service", dms.DefaultServiceURL, "The data models service to use for fetching schema information.")
+	fs.StringVar(&schemaDir, "schema-dir", "", "A local checkout of the data models repository to read schema information from instead of the service.")
+	fs.StringVar(&cacheDir, "cache-dir", validator.DefaultCacheDir(), "The directory schema information fetched from the service is cached in.")
+
+	fs.Parse(args[1:])
+
+	if fs.NArg() > 1 || fs.NArg() == 0 && schemaDir == "" {
+		fmt.Println("A rule file must be specified.")
+		os.Exit(1)
+	}
+
+	var (
+		name string
+		rs   *validator.RuleSet
+		err  error
+	)
+
+	if fs.NArg() == 1 {
+		name = fs.Arg(0)
+
+		if rs, err = validator.OpenRuleSet(name); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+
+		if modelName == "" {
+			modelName = rs.Model
+		}
+
+		if version == "" {
+			version = rs.Version
+		}
+	}
+
+	if modelName == "" {
+		fmt.Println("A model must be specified.")
+		os.Exit(1)
+	}
+
+	provider, err := newProvider(service, schemaDir, cacheDir, false)
+
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	model := loadModel(provider, modelName, version)
+
+	// The rule file kept with the definition files of the revision.
+	if rs == nil {
+		if name = validator.FindRuleFile(model.Path); name == "" {
+			fmt.Printf("No rule file in '%s'.\n", model.Path)
+			os.Exit(1)
+		}
+
+		if rs, err = validator.OpenRuleSet(name); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	}
+
+	problems := rs.Lint(model)
+
+	for _, p := range problems {
+		fmt.Printf("* %s: %s\n", name, p)
+	}
+
+	if len(problems) > 0 {
+		os.Exit(1)
+	}
+
+	fmt.Printf("* %d rules in '%s' are valid for '%s/%s'.\n", len(rs.Rules), name, model.Name, model.Version)
+}
@@ -3,8 +3,12 @@ package validator
 
 import (
 	"bufio"
+	"bytes"
 	"errors"
+	"fmt"
 	"io"
+	"strconv"
+	"strings"
 )
 
 var (
@@ -12,8 +16,116 @@ var (
 	csvErrUnescapedQuote    = errors.New("bare quote")
 	csvErrUnterminatedField = errors.New("unterminated field")
 	csvErrExtraColumns      = errors.New("extra columns")
+	csvErrRecordTooLong     = errors.New("record too long")
 )
 
+// EscapeStyle defines how quote characters are escaped within a quoted field.
+type EscapeStyle int
+
+const (
+	// EscapeDouble escapes a quote by doubling it, e.g. "a ""quoted"" value".
+	EscapeDouble EscapeStyle = iota
+
+	// EscapeBackslash escapes a quote (or a backslash) with a backslash,
+	// e.g. "a \"quoted\" value".
+	EscapeBackslash
+)
+
+// ParseEscapeStyle parses the name of an escape style.
+func ParseEscapeStyle(s string) (EscapeStyle, error) {
+	switch s {
+	case "double", "":
+		return EscapeDouble, nil
+	case "backslash":
+		return EscapeBackslash, nil
+	}
+
+	return EscapeDouble, fmt.Errorf("unknown escape style %s", s)
+}
+
+// QuotingPolicy defines which values must be quoted.
+type QuotingPolicy int
+
+const (
+	// QuoteMinimal only requires values containing special characters to
+	// be quoted.
+	QuoteMinimal QuotingPolicy = iota
+
+	// QuoteAllNonEmpty requires all non-empty values to be quoted.
+	QuoteAllNonEmpty
+
+	// QuoteAll requires all values to be quoted, including empty values.
+	QuoteAll
+)
+
+func (p QuotingPolicy) String() string {
+	switch p {
+	case QuoteAllNonEmpty:
+		return "all-non-empty"
+	case QuoteAll:
+		return "all"
+	}
+
+	return "minimal"
+}
+
+// ParseQuotingPolicy parses the name of a quoting policy.
+func ParseQuotingPolicy(s string) (QuotingPolicy, error) {
+	switch s {
+	case "minimal", "":
+		return QuoteMinimal, nil
+	case "all-non-empty":
+		return QuoteAllNonEmpty, nil
+	case "all":
+		return QuoteAll, nil
+	}
+
+	return QuoteMinimal, fmt.Errorf("unknown quoting policy %s", s)
+}
+
+// Dialect describes the format of the delimited data.
+type Dialect struct {
+	// Delimiter separates values. It may be more than one character.
+	Delimiter string
+
+	// Quote is the character used to quote values.
+	Quote byte
+
+	// Escape is how quote characters are escaped within quoted values.
+	Escape EscapeStyle
+
+	// Quoting defines which values must be quoted.
+	Quoting QuotingPolicy
+}
+
+// DefaultDialect returns the dialect defined by RFC 4180.
+func DefaultDialect() *Dialect {
+	return &Dialect{
+		Delimiter: ",",
+		Quote:     '"',
+		Escape:    EscapeDouble,
+	}
+}
+
+// ParseDelimiter interprets escape sequences such as \t or \x1f in the delimiter.
+func ParseDelimiter(s string) (string, error) {
+	if s == "" {
+		return "", errors.New("delimiter cannot be empty")
+	}
+
+	if !strings.Contains(s, `\`) {
+		return s, nil
+	}
+
+	d, err := strconv.Unquote(`"` + s + `"`)
+
+	if err != nil {
+		return "", fmt.Errorf("invalid delimiter %s", s)
+	}
+
+	return d, nil
+}
+
 func clearRow(row []string) {
 	for i, _ := range row {
 		row[i] = ""
@@ -23,52 +135,102 @@ func clearRow(row []string) {
 // CSVReader provides an interface for reading CSV data
 // (compatible with rfc4180 and extended with the option of having a separator other than ",").
 // Successive calls to the Scan method will step through the 'fields', skipping the separator/newline between the fields.
-// The EndOfRecord method tells when a field is terminated by a line break.
+// The EndOfRecord method tells when a field is terminated by a line break. Quoted fields may
+// contain line breaks in which case a record spans multiple lines.
 type CSVReader struct {
-	sc *bufio.Scanner
+	rd *bufio.Reader
 
 	// If true, the scanner will continue scanning if field-level errors are
 	// encountered. The error should be checked after each call to Scan to
 	// handle the error.
 	ContinueOnError bool
 
-	sep    byte // values separator
+	// MaxRecordSize is the maximum size of a record in bytes. Records that
+	// exceed the size are skipped and reported as an error. Zero means
+	// records are unbounded.
+	MaxRecordSize int
+
+	// Quoting defines which values must be quoted. Values violating the
+	// policy are read as usual and reported by ScanLine once the record
+	// has been read.
+	Quoting QuotingPolicy
+
+	sep    []byte // values separator
+	quote  byte
+	escape EscapeStyle
+
 	eor    bool // true when the most recent field has been terminated by a newline (not a separator).
-	lineno int  // current line number (not record number)
+	lines  int  // number of lines read
+	lineno int  // line number the current record starts on
+	recno  int  // current record number
 	column int  // current column index 1-based
 
+	quoted   bool   // true if the current field is quoted
+	fields   []bool // whether each field in the current record is quoted
+	unquoted []int  // columns in the current record violating the quoting policy
+
 	eof bool
 	// Error. Only set if
 	err error
+	// Error reading from the underlying reader.
+	rerr error
+	// True if the current record exceeds the maximum size.
+	long bool
 
-	// Full line, last valid column value, remaining data in the line.
+	// Full record, last valid column value, remaining data in the record.
 	line  string
 	token []byte
 	data  []byte
 
+	// Buffer the lines of the current record are read into.
+	buf []byte
+
+	// Line ending style of the input, the line ending of the last line read
+	// and the line ending of the current record if it differs from the style.
+	style []byte
+	eol   []byte
+	mixed string
+
 	trail bool
 }
 
 // DefaultReader creates a "standard" CSV reader.
 func DefaultCSVReader(rd io.Reader) *CSVReader {
-	return NewCSVReader(rd, ',')
+	return NewCSVReader(rd, nil)
 }
 
-// NewReader returns a new CSV scanner.
-func NewCSVReader(r io.Reader, sep byte) *CSVReader {
+// NewReader returns a new CSV scanner for the dialect. If the dialect is nil
+// the default dialect is used. Unset delimiter and quote characters default
+// to a comma and double quote.
+func NewCSVReader(r io.Reader, d *Dialect) *CSVReader {
+	if d == nil {
+		d = DefaultDialect()
+	}
+
 	s := &CSVReader{
 		ContinueOnError: true,
+		Quoting:         d.Quoting,
 
-		// Defaults to splitting by line.
-		sc:  bufio.NewScanner(r),
-		sep: sep,
-		eor: true,
+		rd:     bufio.NewReaderSize(r, 64*1024),
+		sep:    []byte(d.Delimiter),
+		quote:  d.Quote,
+		escape: d.Escape,
+		eor:    true,
+	}
+
+	if len(s.sep) == 0 {
+		s.sep = []byte{','}
+	}
+
+	if s.quote == 0 {
+		s.quote = '"'
 	}
 
 	return s
 }
 
-// Line returns the current line as a string.
+// Line returns the current record as a string. If the record spans multiple
+// lines, the lines are joined by a newline.
 func (s *CSVReader) Line() string {
 	return s.line
 }
@@ -78,16 +240,50 @@ func (s *CSVReader) Text() string {
 	return string(s.token)
 }
 
-// LineNumber returns current line number.
+// LineNumber returns the line number the current record starts on.
 func (s *CSVReader) LineNumber() int {
 	return s.lineno
 }
 
+// RecordNumber returns the current record number. This differs from the
+// line number if empty lines were skipped or records span multiple lines.
+func (s *CSVReader) RecordNumber() int {
+	return s.recno
+}
+
+// LineEnding returns the line ending style of the input: LF, CRLF or CR.
+func (s *CSVReader) LineEnding() string {
+	return lineEndingName(s.style)
+}
+
+// MixedLineEnding returns the line ending of the current record if it
+// differs from the line ending style of the input. Otherwise it is empty.
+func (s *CSVReader) MixedLineEnding() string {
+	return s.mixed
+}
+
 // ColumnNumber returns the column index of the current field.
 func (s *CSVReader) ColumnNumber() int {
 	return s.column
 }
 
+// Quoted returns true if the current field is quoted.
+func (s *CSVReader) Quoted() bool {
+	return s.quoted
+}
+
+// QuotedFields returns whether each field of the current record read so far
+// is quoted. This distinguishes an empty value from a quoted empty string.
+func (s *CSVReader) QuotedFields() []bool {
+	return s.fields
+}
+
+// UnquotedColumns returns the columns of the current record that are not
+// quoted as required by the quoting policy.
+func (s *CSVReader) UnquotedColumns() []int {
+	return s.unquoted
+}
+
 // EndOfRecord returns true when the most recent field has been terminated by a newline (not a separator).
 func (s *CSVReader) EndOfRecord() bool {
 	return s.eor
@@ -95,8 +291,8 @@ func (s *CSVReader) EndOfRecord() bool {
 
 // Err returns an error if one occurred during scanning.
 func (s *CSVReader) Err() error {
-	if err := s.sc.Err(); err != nil {
-		return err
+	if s.rerr != nil {
+		return s.rerr
 	}
 
 	if s.err != nil {
@@ -133,7 +329,8 @@ func (s *CSVReader) Read() ([]string, error) {
 }
 
 // ScanLine scans all fields in one line and puts the values in
-// the passed slice.
+// the passed slice. If values are not quoted as required by the quoting
+// policy, the record is read as a whole and csvErrUnquotedField is returned.
 func (s *CSVReader) ScanLine(r []string) error {
 	var (
 		err error
@@ -158,7 +355,11 @@ func (s *CSVReader) ScanLine(r []string) error {
 		}
 	}
 
-	return s.Err()
+	if err = s.Err(); err == nil && len(s.unquoted) > 0 {
+		return csvErrUnquotedField
+	}
+
+	return err
 }
 
 func (s *CSVReader) Scan() bool {
@@ -181,9 +382,18 @@ func (s *CSVReader) Scan() bool {
 
 		// Scan until there is a non-empty line to parse.
 		for {
-			if !s.sc.Scan() {
+			s.buf = s.buf[:0]
+			s.long = false
+			s.mixed = ""
+			s.lines++
+
+			start := s.lines
+
+			if !s.readLine() {
+				s.lines--
+
 				// If there was an error, return. Otherwise mark as EOF.
-				if err := s.sc.Err(); err != nil {
+				if s.rerr != nil {
 					return false
 				}
 
@@ -191,17 +401,35 @@ func (s *CSVReader) Scan() bool {
 				break
 			}
 
-			// Set the current line. Add the new line to parsing.
-			s.line = s.sc.Text()
-
 			// Skip empty lines.
-			if s.line != "" {
-				s.data = s.sc.Bytes()
-				break
+			if len(s.buf) == 0 && !s.long {
+				continue
 			}
+
+			// Set the current line and start a new record.
+			s.line = string(s.buf)
+			s.data = s.buf
+
+			s.lineno = start
+			s.recno++
+			s.column = 0
+			s.fields = s.fields[:0]
+			s.unquoted = s.unquoted[:0]
+			break
 		}
 	}
 
+	// The record exceeds the maximum size. The remainder of the record has
+	// been skipped so the record is reported as a whole.
+	if s.long {
+		s.err = csvErrRecordTooLong
+		s.token = nil
+		s.data = nil
+		s.eor = true
+
+		return s.ContinueOnError
+	}
+
 	adv, token, trail, err := s.scanField(s.data)
 
 	// Advance the section of the line for the next field.
@@ -215,10 +443,20 @@ func (s *CSVReader) Scan() bool {
 	// Set the token if no error occurred otherwise mark as the end of record.
 	if err == nil {
 		s.token = token
+		s.fields = append(s.fields, s.quoted)
+
+		if !s.quoted && s.requiresQuotes(token) {
+			s.unquoted = append(s.unquoted, s.column)
+		}
 	} else {
 		if s.ContinueOnError {
 			s.token = s.data
 			s.eor = true
+
+			// The record was truncated.
+			if err == csvErrRecordTooLong {
+				s.token = nil
+			}
 		} else {
 			return false
 		}
@@ -231,7 +469,22 @@ func (s *CSVReader) Scan() bool {
 	return true
 }
 
+// requiresQuotes returns true if the value must be quoted according to the
+// quoting policy.
+func (s *CSVReader) requiresQuotes(value []byte) bool {
+	switch s.Quoting {
+	case QuoteAll:
+		return true
+	case QuoteAllNonEmpty:
+		return len(value) > 0
+	}
+
+	return false
+}
+
 func (s *CSVReader) scanField(data []byte) (int, []byte, bool, error) {
+	s.quoted = false
+
 	// Special case.
 	if s.trail {
 		s.column++
@@ -244,76 +497,90 @@ func (s *CSVReader) scanField(data []byte) (int, []byte, bool, error) {
 		return 0, nil, false, nil
 	}
 
-	// Previous iteration was the end of a record. Increment line and reset column.
-	if s.eor {
-		s.column = 0
-		s.lineno++
-	}
-
 	s.column++
 	s.eor = false
 
 	// Quoted field.
-	if data[0] == '"' {
-		var (
-			eq    int
-			oq    bool
-			c, pc byte
-		)
+	if data[0] == s.quote {
+		var escaped int
+
+		s.quoted = true
 
 		// Scan until the end quote is found.
-		for i := 1; i < len(data); i++ {
-			c = data[i]
-
-			// Successive quotes denote an escaped quote. Clear the previous byte
-			// to escaped quotes are not overlapped.
-			if c == '"' {
-				if pc == '"' {
-					pc = 0
-					oq = false
-					eq++
-					continue
+		for i := 1; ; i++ {
+			// Ran out of bytes within the quoted value. The value continues
+			// on the next line.
+			if i == len(data) {
+				if data = s.more(data); s.long {
+					s.eor = true
+					return 0, nil, false, csvErrRecordTooLong
+				} else if i == len(data) {
+					break
 				}
+			}
 
-				// Open quote.
-				if oq {
-					return 0, nil, false, csvErrUnescapedQuote
+			c := data[i]
+
+			// Backslash escapes the next character.
+			if s.escape == EscapeBackslash && c == '\\' {
+				if i == len(data)-1 {
+					if data = s.more(data); s.long {
+						s.eor = true
+						return 0, nil, false, csvErrRecordTooLong
+					} else if i == len(data)-1 {
+						break
+					}
 				}
 
-				oq = true
+				escaped++
+				i++
+				continue
 			}
 
-			// End of field with a trailing comma.
-			if pc == '"' && c == s.sep {
-				return i + 1, unescapeQuotes(data[1:i-1], eq), true, nil
+			if c != s.quote {
+				continue
 			}
 
-			// Shift previous characters.
-			pc = c
-		}
+			// Successive quotes denote an escaped quote.
+			if s.escape == EscapeDouble && i < len(data)-1 && data[i+1] == s.quote {
+				escaped++
+				i++
+				continue
+			}
 
-		// Ran out of bytes.
-		s.eor = true
+			value := s.unescape(data[1:i], escaped)
 
-		// Final character in the line is a quote of the last field.
-		if c == '"' {
-			return len(data), unescapeQuotes(data[1:len(data)-1], eq), false, nil
+			// Final character in the line is the end quote of the last field.
+			if i == len(data)-1 {
+				s.eor = true
+				return len(data), value, false, nil
+			}
+
+			// End of field with a trailing separator.
+			if bytes.HasPrefix(data[i+1:], s.sep) {
+				return i + 1 + len(s.sep), value, true, nil
+			}
+
+			// End quote is followed by other characters.
+			return 0, nil, false, csvErrUnescapedQuote
 		}
 
-		// End of line without a terminated quote.
+		// End of input without a terminated quote.
+		s.eor = true
+
 		return 0, nil, false, csvErrUnterminatedField
 	}
 
-	// Unquoted fields. Only fail if a double quote is found.
+	// Unquoted fields. Only fail if a quote is found.
 	for i, c := range data {
-		if c == s.sep {
+		if c == s.sep[0] && bytes.HasPrefix(data[i:], s.sep) {
 			s.eor = false
-			return i + 1, data[0:i], true, nil
+			return i + len(s.sep), data[0:i], true, nil
 		}
 
 		// Unquoted field with quote.
-		if c == '"' {
-			return 0, nil, false, csvErrUnquotedField
+		if c == s.quote {
+			return 0, nil, false, csvErrUnescapedQuote
 		}
 	}
 
@@ -323,8 +590,251 @@ func (s *CSVReader) scanField(data []byte) (int, []byte, bool, error) {
 	return len(data), data, false, nil
 }
 
+// Line ending styles.
+var (
+	lineEndingLF   = []byte("\n")
+	lineEndingCRLF = []byte("\r\n")
+	lineEndingCR   = []byte("\r")
+)
+
+func lineEndingName(eol []byte) string {
+	switch string(eol) {
+	case "\n":
+		return "LF"
+	case "\r\n":
+		return "CRLF"
+	case "\r":
+		return "CR"
+	}
+
+	return ""
+}
+
+// detectLineEnding sets the line ending style of the input based on the
+// first line ending found in the buffered data. Defaults to LF.
+func (s *CSVReader) detectLineEnding() {
+	s.style = lineEndingLF
+
+	b, _ := s.rd.Peek(s.rd.Size())
+
+	i := bytes.IndexAny(b, "\r\n")
+
+	if i == -1 || b[i] == '\n' {
+		return
+	}
+
+	// The carriage return is the last buffered byte, assume it is followed
+	// by a newline unless the end of the input has been reached.
+	if i == len(b)-1 {
+		if len(b) == s.rd.Size() {
+			s.style = lineEndingCRLF
+		} else {
+			s.style = lineEndingCR
+		}
+
+		return
+	}
+
+	if b[i+1] == '\n' {
+		s.style = lineEndingCRLF
+	} else {
+		s.style = lineEndingCR
+	}
+}
+
+// readLine appends the next line to the buffer without the line terminator.
+// It returns false if there are no more lines. If the record exceeds the
+// maximum size, the line is truncated and the remainder of the record is
+// skipped.
+func (s *CSVReader) readLine() bool {
+	var (
+		read  bool
+		start = len(s.buf)
+	)
+
+	if s.style == nil {
+		s.detectLineEnding()
+	}
+
+	// Lines are split on the final byte of the line ending.
+	term := s.style[len(s.style)-1]
+
+	s.eol = nil
+
+	for {
+		frag, err := s.rd.ReadSlice(term)
+
+		if err != nil && err != bufio.ErrBufferFull && err != io.EOF {
+			s.rerr = err
+			return false
+		}
+
+		// The previous line ended with a CRLF in a file using CR. Only the
+		// line endings of records are compared to the style, not those within
+		// quoted values.
+		if !read && term == '\r' && len(frag) > 0 && frag[0] == '\n' {
+			frag = frag[1:]
+
+			if start == 0 {
+				s.mixed = "CRLF"
+			}
+		}
+
+		if len(frag) > 0 {
+			read = true
+		}
+
+		line := frag
+
+		// Drop the line terminator.
+		if err == nil {
+			line = line[:len(line)-1]
+		}
+
+		if s.MaxRecordSize > 0 && len(s.buf)+len(line) > s.MaxRecordSize {
+			n := s.MaxRecordSize - len(s.buf)
+
+			// The line ending kept in a multi-line value may already exceed
+			// the size, in which case none of the line is kept.
+			if n < 0 {
+				n = 0
+			}
+
+			s.buf = append(s.buf, line[:n]...)
+			s.long = true
+
+			// Determine if the skipped data starts within a quoted value
+			// from the raw record data read so far.
+			q, esc := s.quoteState(false, false, []byte(s.line))
+			q, esc = s.quoteState(q, esc, s.buf[start:])
+
+			s.skip(q, esc, term, frag[n:], err)
+			return true
+		}
+
+		s.buf = append(s.buf, line...)
+
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+
+		// Last line without a line ending.
+		if err != nil {
+			return read
+		}
+
+		s.eol = lineEndingLF
+
+		if term == '\r' {
+			s.eol = lineEndingCR
+		} else if len(s.buf) > start && s.buf[len(s.buf)-1] == '\r' {
+			s.buf = s.buf[:len(s.buf)-1]
+			s.eol = lineEndingCRLF
+		}
+
+		if !bytes.Equal(s.eol, s.style) {
+			s.mixed = lineEndingName(s.eol)
+		}
+
+		return true
+	}
+}
+
+// quoteState returns whether the end of the data is within a quoted value
+// and whether the next byte is escaped given the state at the start of the data.
+func (s *CSVReader) quoteState(q, esc bool, data []byte) (bool, bool) {
+	for _, c := range data {
+		switch {
+		case esc:
+			esc = false
+		case q && s.escape == EscapeBackslash && c == '\\':
+			esc = true
+		case c == s.quote:
+			q = !q
+		}
+	}
+
+	return q, esc
+}
+
+// skip discards the remainder of the record starting with the unread
+// portion of the current line. Line breaks within quoted values are counted
+// so line numbers remain accurate.
+func (s *CSVReader) skip(q, esc bool, term byte, frag []byte, err error) {
+	for {
+		for _, c := range frag {
+			switch {
+			case esc:
+				esc = false
+			case q && s.escape == EscapeBackslash && c == '\\':
+				esc = true
+			case c == s.quote:
+				q = !q
+			case c == term:
+				if !q {
+					return
+				}
+
+				s.lines++
+			}
+		}
+
+		if err != nil && err != bufio.ErrBufferFull {
+			if err != io.EOF {
+				s.rerr = err
+			}
+
+			return
+		}
+
+		frag, err = s.rd.ReadSlice(term)
+	}
+}
+
+// more appends the next line to the data of the current record for a quoted
+// value containing a line break. The line ending is kept as is in the value.
+// If there are no more lines, the data is returned as is.
+func (s *CSVReader) more(data []byte) []byte {
+	var (
+		off = len(s.buf) - len(data)
+		end = len(s.buf)
+	)
+
+	// Last line of the input.
+	if s.eol == nil {
+		return data
+	}
+
+	s.buf = append(s.buf, s.eol...)
+	s.lines++
+
+	// The line ending is within the value, so only the ending of the line
+	// that ends the record is compared to the style.
+	s.mixed = ""
+
+	if !s.readLine() {
+		s.buf = s.buf[:end]
+		s.lines--
+		return data
+	}
+
+	s.line += string(s.buf[end:])
+	s.data = s.buf[off:]
+
+	return s.data
+}
+
+// unescape removes the escape characters from a quoted value.
+func (s *CSVReader) unescape(b []byte, count int) []byte {
+	if s.escape == EscapeBackslash {
+		return unescapeBackslashes(b, count)
+	}
+
+	return unescapeQuotes(b, s.quote, count)
+}
+
 // Removes escaped quotes from the string.
-func unescapeQuotes(b []byte, count int) []byte {
+func unescapeQuotes(b []byte, quote byte, count int) []byte {
 	if count == 0 {
 		return b
 	}
@@ -332,10 +842,29 @@ func unescapeQuotes(b []byte, count int) []byte {
 	for i, j := 0, 0; i < len(b); i, j = i+1, j+1 {
 		b[j] = b[i]
 
-		if b[i] == '"' && (i < len(b)-1 && b[i+1] == '"') {
+		if b[i] == quote && (i < len(b)-1 && b[i+1] == quote) {
 			i++
 		}
 	}
 
 	return b[:len(b)-count]
 }
+
+// Removes backslashes escaping the following character.
+func unescapeBackslashes(b []byte, count int) []byte {
+	if count == 0 {
+		return b
+	}
+
+	j := 0
+
+	for i := 0; i < len(b); i, j = i+1, j+1 {
+		if b[i] == '\\' && i < len(b)-1 {
+			i++
+		}
+
+		b[j] = b[i]
+	}
+
+	return b[:j]
+}
@@ -142,7 +142,7 @@ func TestCSVScanLine(t *testing.T) {
 		}
 
 		if !compareRows(table[i], row) {
-			t.Errorf("%d: wrong row, got %v", row)
+			t.Errorf("%d: wrong row, got %v", i, row)
 		}
 
 		i++
@@ -300,6 +300,304 @@ func TestCSVExtraColumns(t *testing.T) {
 	}
 }
 
+func TestCSVDialect(t *testing.T) {
+	tests := []struct {
+		Dialect *Dialect
+		Input   string
+		Row     []string
+		Error   error
+	}{
+		{&Dialect{Delimiter: "\t"}, "Joe\t\"M\"\tGA", []string{"Joe", "M", "GA"}, nil},
+		{&Dialect{Delimiter: "||"}, `Joe||"M|F"||GA`, []string{"Joe", "M|F", "GA"}, nil},
+		{&Dialect{Delimiter: ",", Quote: '\''}, `Joe,'O''Neil',GA`, []string{"Joe", "O'Neil", "GA"}, nil},
+		{&Dialect{Delimiter: ",", Escape: EscapeBackslash}, `Joe,"say \"hi\" \\",GA`, []string{"Joe", `say "hi" \`, "GA"}, nil},
+		{&Dialect{Delimiter: ",", Quoting: QuoteAllNonEmpty}, `"Joe","M",""`, []string{"Joe", "M", ""}, nil},
+		{&Dialect{Delimiter: ",", Quoting: QuoteAllNonEmpty}, `"Joe",M,"GA"`, nil, csvErrUnquotedField},
+		{&Dialect{Delimiter: ",", Quoting: QuoteAllNonEmpty}, `"Joe","M",GA`, nil, csvErrUnquotedField},
+	}
+
+	for i, test := range tests {
+		cr := NewCSVReader(bytes.NewBufferString(test.Input), test.Dialect)
+		row := make([]string, 3)
+
+		err := cr.ScanLine(row)
+
+		if test.Error != nil {
+			if err != test.Error {
+				t.Errorf("%d: expected error %s, got %v", i, test.Error, err)
+			}
+
+			continue
+		}
+
+		if err != nil && err != io.EOF {
+			t.Errorf("%d: unexpected error: %s", i, err)
+		}
+
+		if !compareRows(test.Row, row) {
+			t.Errorf("%d: expected %q, got %q", i, test.Row, row)
+		}
+	}
+}
+
+func TestCSVQuotingPolicy(t *testing.T) {
+	tests := []struct {
+		Quoting  QuotingPolicy
+		Input    string
+		Unquoted []int
+	}{
+		{QuoteMinimal, `Joe,,GA`, nil},
+		{QuoteAllNonEmpty, `"Joe",,"GA"`, nil},
+		{QuoteAllNonEmpty, `Joe,"",GA`, []int{1, 3}},
+		{QuoteAll, `"Joe","","GA"`, nil},
+		{QuoteAll, `"Joe",,"GA"`, []int{2}},
+		{QuoteAll, `"Joe","M",`, []int{3}},
+	}
+
+	for i, test := range tests {
+		cr := NewCSVReader(bytes.NewBufferString(test.Input), &Dialect{Quoting: test.Quoting})
+		row := make([]string, 3)
+
+		err := cr.ScanLine(row)
+
+		if test.Unquoted == nil {
+			if err != nil && err != io.EOF {
+				t.Errorf("%d: unexpected error: %s", i, err)
+			}
+
+			continue
+		}
+
+		if err != csvErrUnquotedField {
+			t.Errorf("%d: expected error %s, got %v", i, csvErrUnquotedField, err)
+		}
+
+		if fmt.Sprint(cr.UnquotedColumns()) != fmt.Sprint(test.Unquoted) {
+			t.Errorf("%d: expected columns %v, got %v", i, test.Unquoted, cr.UnquotedColumns())
+		}
+
+		// The record is still read as a whole.
+		if row[0] != "Joe" {
+			t.Errorf("%d: expected full row, got %q", i, row)
+		}
+	}
+
+	// Unquoted values containing a quote are bare quotes regardless of the policy.
+	cr := DefaultCSVReader(bytes.NewBufferString(`Joe,5'11",GA`))
+
+	if err := cr.ScanLine(make([]string, 3)); err != csvErrUnescapedQuote {
+		t.Errorf("expected error %s, got %v", csvErrUnescapedQuote, err)
+	}
+}
+
+func TestCSVMultilineField(t *testing.T) {
+	rows := []string{
+		`id,note,date`,
+		`1,"first line`,
+		``,
+		`third line",2015-01-01`,
+		`2,"a ""quoted""`,
+		`value",2015-01-02`,
+		``,
+		`3,"unterminated,2015-01-03`,
+		`4,x,2015-01-04`,
+	}
+
+	expected := []struct {
+		Row    []string
+		Line   int
+		Record int
+		Error  error
+	}{
+		{[]string{"id", "note", "date"}, 1, 1, nil},
+		{[]string{"1", "first line\n\nthird line", "2015-01-01"}, 2, 2, nil},
+		{[]string{"2", "a \"quoted\"\nvalue", "2015-01-02"}, 5, 3, nil},
+		{nil, 8, 4, csvErrUnterminatedField},
+	}
+
+	buf := bytes.NewBufferString(strings.Join(rows, "\n"))
+	cr := DefaultCSVReader(buf)
+
+	row := make([]string, 3)
+
+	for i, exp := range expected {
+		err := cr.ScanLine(row)
+
+		if err != exp.Error {
+			t.Errorf("%d: expected error %v, got %v", i, exp.Error, err)
+		} else if err == nil && !compareRows(exp.Row, row) {
+			t.Errorf("%d: expected %q, got %q", i, exp.Row, row)
+		}
+
+		if cr.LineNumber() != exp.Line {
+			t.Errorf("%d: expected line %d, got %d", i, exp.Line, cr.LineNumber())
+		}
+
+		if cr.RecordNumber() != exp.Record {
+			t.Errorf("%d: expected record %d, got %d", i, exp.Record, cr.RecordNumber())
+		}
+	}
+
+	// The unterminated value consumes the remaining input.
+	if cr.ColumnNumber() != 2 {
+		t.Errorf("expected column 2, got %d", cr.ColumnNumber())
+	}
+
+	if err := cr.ScanLine(row); err != io.EOF {
+		t.Errorf("expected EOF, got %v", err)
+	}
+}
+
+func TestCSVLongRecord(t *testing.T) {
+	wide := strings.Repeat("x", 100000)
+
+	rows := []string{
+		`1,"` + wide + `",a`,
+		`2,"short",b`,
+		`3,"` + wide + "\n" + wide + `",c`,
+		`4,"multi`,
+		`line",d`,
+	}
+
+	input := strings.Join(rows, "\n")
+
+	// Unbounded records.
+	cr := DefaultCSVReader(bytes.NewBufferString(input))
+	row := make([]string, 3)
+
+	if err := cr.ScanLine(row); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if row[1] != wide {
+		t.Errorf("expected value of %d bytes, got %d", len(wide), len(row[1]))
+	}
+
+	// Records exceeding the maximum size are skipped.
+	cr = DefaultCSVReader(bytes.NewBufferString(input))
+	cr.MaxRecordSize = 1000
+
+	expected := []struct {
+		ID    string
+		Line  int
+		Error error
+	}{
+		{"", 1, csvErrRecordTooLong},
+		{"2", 2, nil},
+		{"", 3, csvErrRecordTooLong},
+		{"4", 5, nil},
+	}
+
+	for i, exp := range expected {
+		err := cr.ScanLine(row)
+
+		if err != exp.Error {
+			t.Errorf("%d: expected error %v, got %v", i, exp.Error, err)
+		} else if row[0] != exp.ID {
+			t.Errorf("%d: expected id %s, got %s", i, exp.ID, row[0])
+		}
+
+		if cr.LineNumber() != exp.Line {
+			t.Errorf("%d: expected line %d, got %d", i, exp.Line, cr.LineNumber())
+		}
+
+		if cr.RecordNumber() != i+1 {
+			t.Errorf("%d: expected record %d, got %d", i, i+1, cr.RecordNumber())
+		}
+	}
+
+	if err := cr.ScanLine(row); err != io.EOF {
+		t.Errorf("expected EOF, got %v", err)
+	}
+
+	// The line ending within the value reaches the maximum size.
+	cr = DefaultCSVReader(bytes.NewBufferString("\"abcd\nx\",1\n2,3\n"))
+	cr.MaxRecordSize = 5
+
+	row = row[:2]
+
+	if err := cr.ScanLine(row); err != csvErrRecordTooLong {
+		t.Errorf("expected record too long, got %v", err)
+	}
+
+	if err := cr.ScanLine(row); err != nil || row[0] != "2" || cr.LineNumber() != 3 {
+		t.Errorf("expected record on line 3, got %q on line %d (%v)", row, cr.LineNumber(), err)
+	}
+
+	if err := cr.ScanLine(row); err != io.EOF {
+		t.Errorf("expected EOF, got %v", err)
+	}
+}
+
+func TestCSVLineEndings(t *testing.T) {
+	tests := []struct {
+		Input  string
+		Ending string
+		Rows   [][]string
+		Mixed  []string
+	}{
+		{"a,b\n1,2\n", "LF", [][]string{{"a", "b"}, {"1", "2"}}, []string{"", ""}},
+		{"a,b\r\n1,2\r\n", "CRLF", [][]string{{"a", "b"}, {"1", "2"}}, []string{"", ""}},
+		{"a,b\r1,2\r", "CR", [][]string{{"a", "b"}, {"1", "2"}}, []string{"", ""}},
+		{"a,b\r\n1,\"x\r\ny\"\r\n", "CRLF", [][]string{{"a", "b"}, {"1", "x\r\ny"}}, []string{"", ""}},
+		{"a,b\n1,\"x\ry\"\n", "LF", [][]string{{"a", "b"}, {"1", "x\ry"}}, []string{"", ""}},
+		{"a,b\r\n1,2\n3,4\r\n", "CRLF", [][]string{{"a", "b"}, {"1", "2"}, {"3", "4"}}, []string{"", "LF", ""}},
+		{"a,b\n1,2\r\n", "LF", [][]string{{"a", "b"}, {"1", "2"}}, []string{"", "CRLF"}},
+		// Line breaks within quoted values are not record line endings.
+		{"a,b\r\n\"x\ny\",1\r\n", "CRLF", [][]string{{"a", "b"}, {"x\ny", "1"}}, []string{"", ""}},
+		{"a,b\r\n\"x\ny\",1\n", "CRLF", [][]string{{"a", "b"}, {"x\ny", "1"}}, []string{"", "LF"}},
+	}
+
+	for i, test := range tests {
+		cr := DefaultCSVReader(bytes.NewBufferString(test.Input))
+		row := make([]string, 2)
+
+		for j, exp := range test.Rows {
+			if err := cr.ScanLine(row); err != nil {
+				t.Errorf("%d/%d: unexpected error: %s", i, j, err)
+				continue
+			}
+
+			if !compareRows(exp, row) {
+				t.Errorf("%d/%d: expected %q, got %q", i, j, exp, row)
+			}
+
+			if cr.MixedLineEnding() != test.Mixed[j] {
+				t.Errorf("%d/%d: expected mixed line ending %q, got %q", i, j, test.Mixed[j], cr.MixedLineEnding())
+			}
+
+			if cr.LineNumber() != j+1 {
+				t.Errorf("%d/%d: expected line %d, got %d", i, j, j+1, cr.LineNumber())
+			}
+		}
+
+		if cr.LineEnding() != test.Ending {
+			t.Errorf("%d: expected %s line endings, got %s", i, test.Ending, cr.LineEnding())
+		}
+
+		if err := cr.ScanLine(row); err != io.EOF {
+			t.Errorf("%d: expected EOF, got %v", i, err)
+		}
+	}
+}
+
+func TestParseDelimiter(t *testing.T) {
+	for in, exp := range map[string]string{
+		",":    ",",
+		`\t`:   "\t",
+		"|":    "|",
+		`\x1f`: "\x1f",
+		`~|~`:  "~|~",
+		"\t":   "\t",
+	} {
+		if d, err := ParseDelimiter(in); err != nil {
+			t.Errorf("%s: unexpected error: %s", in, err)
+		} else if d != exp {
+			t.Errorf("%s: expected %q, got %q", in, exp, d)
+		}
+	}
+}
+
 func BenchmarkCSVReaderScan(b *testing.B) {
 	cr := DefaultCSVReader(&bytes.Buffer{})
 
@@ -0,0 +1,200 @@
+package validator
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/chop-dbhi/data-models-service/client"
+)
+
+// ISO8601Layouts are the ISO 8601 variants of datetimes with a T or space
+// separator and an optional Z or UTC offset. Fractional seconds are accepted
+// by all layouts with seconds.
+var ISO8601Layouts = []string{
+	"2006-01-02T15:04:05",
+	"2006-01-02T15:04:05Z07:00",
+	"2006-01-02T15:04:05Z0700",
+	"2006-01-02 15:04:05Z07:00",
+	"2006-01-02 15:04:05Z0700",
+	"2006-01-02T15:04",
+	"2006-01-02T15:04Z07:00",
+}
+
+var (
+	// DefaultDateLayouts are the layouts accepted for dates. Since dates are
+	// a subset of datetimes, datetimes are also valid dates.
+	DefaultDateLayouts = []string{DateLayout}
+
+	// DefaultDatetimeLayouts are the layouts accepted for datetimes.
+	DefaultDatetimeLayouts = append([]string{DatetimeLayout}, ISO8601Layouts...)
+
+	// DefaultTimeLayouts are the layouts accepted for times of day.
+	DefaultTimeLayouts = []string{"15:04:05", "15:04", "15:04:05Z07:00"}
+)
+
+// dateLayouts are the default date layouts followed by the default datetime
+// layouts.
+var dateLayouts = append(append([]string{}, DefaultDateLayouts...), DefaultDatetimeLayouts...)
+
+// ExpandLayouts replaces the name iso8601 in the layouts by the ISO 8601
+// layouts. Other layouts are Go time layouts such as 01/02/2006.
+func ExpandLayouts(layouts []string) []string {
+	var expanded []string
+
+	for _, l := range layouts {
+		if strings.EqualFold(l, "iso8601") {
+			expanded = append(expanded, ISO8601Layouts...)
+		} else {
+			expanded = append(expanded, l)
+		}
+	}
+
+	return expanded
+}
+
+// TimezonePolicy defines whether datetimes may or must have a timezone.
+type TimezonePolicy int
+
+const (
+	// TimezoneAny accepts datetimes with or without a timezone.
+	TimezoneAny TimezonePolicy = iota
+
+	// TimezoneRequired requires datetimes to have a Z or UTC offset.
+	TimezoneRequired
+
+	// TimezoneNone requires datetimes to not have a timezone.
+	TimezoneNone
+
+	// TimezoneUTC requires datetimes with a timezone to be in UTC. Datetimes
+	// without one are assumed to be in UTC.
+	TimezoneUTC
+)
+
+func (p TimezonePolicy) String() string {
+	switch p {
+	case TimezoneRequired:
+		return "required"
+	case TimezoneNone:
+		return "none"
+	case TimezoneUTC:
+		return "utc"
+	}
+
+	return "any"
+}
+
+// ParseTimezonePolicy parses the name of a timezone policy.
+func ParseTimezonePolicy(s string) (TimezonePolicy, error) {
+	switch strings.ToLower(s) {
+	case "", "any":
+		return TimezoneAny, nil
+	case "required":
+		return TimezoneRequired, nil
+	case "none":
+		return TimezoneNone, nil
+	case "utc":
+		return TimezoneUTC, nil
+	}
+
+	return TimezoneAny, fmt.Errorf("unknown timezone policy '%s'", s)
+}
+
+// hasZone returns true if the layout parses a timezone.
+func hasZone(layout string) bool {
+	return strings.Contains(layout, "Z07") || strings.Contains(layout, "-07") || strings.Contains(layout, "MST")
+}
+
+// check returns true if the time parsed with the layout satisfies the policy.
+func (p TimezonePolicy) check(t time.Time, layout string) bool {
+	switch p {
+	case TimezoneRequired:
+		return hasZone(layout)
+	case TimezoneNone:
+		return !hasZone(layout)
+	case TimezoneUTC:
+		_, offset := t.Zone()
+		return !hasZone(layout) || offset == 0
+	}
+
+	return true
+}
+
+// DateFormats are the layouts accepted for date, datetime and time fields
+// and the timezone policy.
+type DateFormats struct {
+	// Layouts for date, datetime and time fields. The defaults are used if
+	// empty.
+	Date     []string
+	Datetime []string
+	Time     []string
+
+	// Fields maps fields to the layouts accepted for them. Fields are named
+	// by table.field or field, the former taking precedence.
+	Fields map[string][]string
+
+	Timezone TimezonePolicy
+}
+
+// Layouts returns the layouts accepted for the field of the table. Nil is
+// returned if the defaults apply.
+func (d *DateFormats) Layouts(table string, f *client.Field) []string {
+	if d == nil {
+		return nil
+	}
+
+	if l, ok := d.Fields[strings.ToLower(table+"."+f.Name)]; ok {
+		return l
+	}
+
+	if l, ok := d.Fields[strings.ToLower(f.Name)]; ok {
+		return l
+	}
+
+	switch CanonicalType(f.Type) {
+	case "date":
+		return d.Date
+	case "datetime":
+		return d.Datetime
+	case "time":
+		return d.Time
+	}
+
+	return nil
+}
+
+// Bind sets the layouts of the date, datetime and time validators of the
+// field. The timezone policy only applies to datetimes, so dates and times
+// are accepted without a timezone.
+func (d *DateFormats) Bind(table string, f *client.Field, vs []*BoundValidator) {
+	if d == nil {
+		return
+	}
+
+	for _, bv := range vs {
+		if bv.Validator != DateValidator && bv.Validator != DatetimeValidator && bv.Validator != TimeValidator {
+			continue
+		}
+
+		bv.Context = Context{
+			"layouts": d.Layouts(table, f),
+		}
+
+		if bv.Validator == DatetimeValidator {
+			bv.Context["timezone"] = d.Timezone
+		}
+	}
+}
+
+// parseTime parses the value with the first layout that accepts it and
+// checks the timezone policy. It returns the layout that was used and
+// whether the policy was satisfied.
+func parseTime(s string, layouts []string, policy TimezonePolicy) (string, bool, bool) {
+	for _, l := range layouts {
+		if t, err := time.Parse(l, s); err == nil {
+			return l, true, policy.check(t, l)
+		}
+	}
+
+	return "", false, false
+}
@@ -0,0 +1,82 @@
+package validator
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/chop-dbhi/data-models-service/client"
+)
+
+// Suffix of a file that is one of several parts of a table, such as
+// person_001, person-2 or person_part3.
+var partSuffix = regexp.MustCompile(`[_.-](?:part)?\d+$`)
+
+// ExpandInputs expands directories to the files they contain and glob
+// patterns to the files they match. Hidden files are skipped. Other inputs,
+// including STDIN denoted by an empty name, are returned as is. An optional
+// :table suffix is kept for each expanded file.
+func ExpandInputs(inputs []string) ([]string, error) {
+	var files []string
+
+	for _, input := range inputs {
+		name, table := input, ""
+
+		if i := strings.LastIndex(input, ":"); i >= 0 {
+			name, table = input[:i], input[i:]
+		}
+
+		var matches []string
+
+		if info, err := os.Stat(name); err == nil && info.IsDir() {
+			entries, err := os.ReadDir(name)
+
+			if err != nil {
+				return nil, err
+			}
+
+			for _, e := range entries {
+				if e.Type().IsRegular() && !strings.HasPrefix(e.Name(), ".") {
+					matches = append(matches, filepath.Join(name, e.Name()))
+				}
+			}
+		} else if strings.ContainsAny(name, "*?[") {
+			if matches, err = filepath.Glob(name); err != nil {
+				return nil, err
+			}
+		} else {
+			files = append(files, input)
+			continue
+		}
+
+		sort.Strings(matches)
+
+		for _, m := range matches {
+			files = append(files, m+table)
+		}
+	}
+
+	return files, nil
+}
+
+// TableName returns the table name for a file name, which is the base name
+// without the extensions.
+func TableName(name string) string {
+	return strings.SplitN(filepath.Base(name), ".", 2)[0]
+}
+
+// AbsentTables returns the names of the tables of the model that are not in
+// the set of delivered tables.
+func AbsentTables(model *client.Model, delivered map[string]bool) []string {
+	var absent []string
+
+	for _, name := range model.Tables.Names() {
+		if !delivered[name] {
+			absent = append(absent, name)
+		}
+	}
+
+	return absent
+}
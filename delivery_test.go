@@ -0,0 +1,92 @@
+package validator
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestExpandInputs(t *testing.T) {
+	dir := t.TempDir()
+
+	for _, name := range []string{"person_001.csv.gz", "person_002.csv.gz", "death.csv", ".hidden"} {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := os.Mkdir(filepath.Join(dir, "sub"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		Inputs []string
+		Files  []string
+	}{
+		{[]string{dir}, []string{"death.csv", "person_001.csv.gz", "person_002.csv.gz"}},
+		{[]string{filepath.Join(dir, "person_*")}, []string{"person_001.csv.gz", "person_002.csv.gz"}},
+		{[]string{filepath.Join(dir, "*.csv") + ":person"}, []string{"death.csv:person"}},
+		{[]string{"other.csv:person", ""}, []string{"other.csv:person", ""}},
+	}
+
+	for i, test := range tests {
+		files, err := ExpandInputs(test.Inputs)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for j, f := range files {
+			if filepath.IsAbs(f) {
+				files[j] = filepath.Base(f)
+			}
+		}
+
+		if fmt.Sprint(files) != fmt.Sprint(test.Files) {
+			t.Errorf("[%d] expected %v, got %v", i, test.Files, files)
+		}
+	}
+}
+
+func TestAbsentTables(t *testing.T) {
+	model, err := (&DirProvider{Dir: writeModelDir(t)}).ModelRevision("pedsnet", "2.0.0")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	absent := AbsentTables(model, map[string]bool{"person": true})
+
+	if fmt.Sprint(absent) != "[visit_occurrence]" {
+		t.Errorf("expected visit_occurrence to be absent, got %v", absent)
+	}
+}
+
+func TestResultMerge(t *testing.T) {
+	a := NewResult()
+	a.LogError(&ValidationError{Err: ErrTypeMismatchInt, Line: 2, Field: "person_id"})
+
+	b := NewResult()
+	b.LogError(&ValidationError{Err: ErrTypeMismatchInt, Line: 5, Field: "person_id"})
+	b.LogError(&ValidationError{Err: ErrExtraColumns, Line: 3})
+
+	r := NewResult()
+	r.Merge(a, "person_001.csv")
+	r.Merge(b, "person_002.csv")
+
+	errs := r.FieldErrors("person_id")[ErrTypeMismatchInt]
+
+	if len(errs) != 2 || errs[0].File != "person_001.csv" || errs[1].File != "person_002.csv" || errs[1].Line != 5 {
+		t.Errorf("wrong field errors %v", errs)
+	}
+
+	if errs := r.LineErrors()[ErrExtraColumns]; len(errs) != 1 || errs[0].File != "person_002.csv" {
+		t.Errorf("wrong line errors %v", errs)
+	}
+
+	// The merged results are not modified.
+	if b.LineErrors()[ErrExtraColumns][0].File != "" {
+		t.Error("expected source result to be unchanged")
+	}
+}
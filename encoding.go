@@ -0,0 +1,204 @@
+package validator
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/unicode"
+	"golang.org/x/text/transform"
+)
+
+// Supported character encodings of the input.
+const (
+	EncodingAuto        = "auto"
+	EncodingASCII       = "us-ascii"
+	EncodingUTF8        = "utf-8"
+	EncodingUTF16       = "utf-16"
+	EncodingUTF16LE     = "utf-16le"
+	EncodingUTF16BE     = "utf-16be"
+	EncodingLatin1      = "iso-8859-1"
+	EncodingWindows1252 = "windows-1252"
+)
+
+// Number of bytes sampled to detect the encoding.
+const encodingSampleSize = 64 * 1024
+
+var encodingAliases = map[string]string{
+	"":             EncodingUTF8,
+	"auto":         EncodingAuto,
+	"ascii":        EncodingASCII,
+	"us-ascii":     EncodingASCII,
+	"utf8":         EncodingUTF8,
+	"utf-8":        EncodingUTF8,
+	"utf16":        EncodingUTF16,
+	"utf-16":       EncodingUTF16,
+	"utf16le":      EncodingUTF16LE,
+	"utf-16le":     EncodingUTF16LE,
+	"utf16be":      EncodingUTF16BE,
+	"utf-16be":     EncodingUTF16BE,
+	"latin1":       EncodingLatin1,
+	"latin-1":      EncodingLatin1,
+	"iso8859-1":    EncodingLatin1,
+	"iso-8859-1":   EncodingLatin1,
+	"cp1252":       EncodingWindows1252,
+	"windows1252":  EncodingWindows1252,
+	"windows-1252": EncodingWindows1252,
+}
+
+// ParseEncoding normalizes the name of an encoding.
+func ParseEncoding(s string) (string, error) {
+	if enc, ok := encodingAliases[strings.ToLower(s)]; ok {
+		return enc, nil
+	}
+
+	return "", fmt.Errorf("unknown encoding %s", s)
+}
+
+// detectEncoding detects the encoding of the sample based on the byte order
+// mark and the byte statistics. Pure ASCII is reported as such since it is
+// valid in all single byte encodings.
+func detectEncoding(b []byte) string {
+	switch {
+	case bytes.HasPrefix(b, bom):
+		return EncodingUTF8
+	case bytes.HasPrefix(b, []byte{0xff, 0xfe}):
+		return EncodingUTF16LE
+	case bytes.HasPrefix(b, []byte{0xfe, 0xff}):
+		return EncodingUTF16BE
+	}
+
+	// UTF-16 without a BOM. Mostly ASCII text has a zero in every
+	// other byte.
+	var even, odd int
+
+	for i := 0; i+1 < len(b); i += 2 {
+		if b[i] == 0 && b[i+1] != 0 {
+			even++
+		} else if b[i] != 0 && b[i+1] == 0 {
+			odd++
+		}
+	}
+
+	if pairs := len(b) / 2; pairs > 0 {
+		if odd*10 > pairs*4 {
+			return EncodingUTF16LE
+		}
+
+		if even*10 > pairs*4 {
+			return EncodingUTF16BE
+		}
+	}
+
+	ascii := true
+
+	for _, c := range b {
+		if c >= 0x80 {
+			ascii = false
+			break
+		}
+	}
+
+	if ascii {
+		return EncodingASCII
+	}
+
+	// The sample may end in the middle of a multi-byte character.
+	if valid := b; !utf8.Valid(valid) {
+		for i := 1; i < utf8.UTFMax && i < len(b); i++ {
+			if utf8.RuneStart(b[len(b)-i]) {
+				valid = b[:len(b)-i]
+				break
+			}
+		}
+
+		if utf8.Valid(valid) && len(valid) < len(b) {
+			return EncodingUTF8
+		}
+	} else {
+		return EncodingUTF8
+	}
+
+	// C1 control characters are unlikely in Latin-1 text, but are
+	// printable characters in Windows-1252.
+	for _, c := range b {
+		if c >= 0x80 && c <= 0x9f {
+			return EncodingWindows1252
+		}
+	}
+
+	return EncodingLatin1
+}
+
+// encodingsCompatible returns true if content detected as one encoding can
+// be decoded as the declared encoding.
+func encodingsCompatible(declared, detected string) bool {
+	if declared == detected {
+		return true
+	}
+
+	switch detected {
+	case EncodingASCII:
+		switch declared {
+		case EncodingUTF8, EncodingLatin1, EncodingWindows1252:
+			return true
+		}
+	case EncodingLatin1:
+		return declared == EncodingWindows1252
+	case EncodingUTF16LE, EncodingUTF16BE:
+		return declared == EncodingUTF16
+	}
+
+	return false
+}
+
+// newDecoder returns a reader that transcodes the input from the encoding
+// to UTF-8. The encoding detected from a sample of the input is returned as
+// well. If the encoding is auto, the detected encoding is used.
+func newDecoder(r io.Reader, encoding string) (io.Reader, string, string) {
+	br := bufio.NewReaderSize(r, encodingSampleSize)
+	sample, _ := br.Peek(encodingSampleSize)
+
+	detected := detectEncoding(sample)
+
+	if encoding == EncodingAuto {
+		encoding = detected
+
+		if encoding == EncodingASCII {
+			encoding = EncodingUTF8
+		}
+	}
+
+	// Use the byte order mark or detected byte order.
+	if encoding == EncodingUTF16 {
+		if detected == EncodingUTF16BE {
+			encoding = EncodingUTF16BE
+		} else {
+			encoding = EncodingUTF16LE
+		}
+	}
+
+	// Invalid input is decoded as the replacement character. The byte order
+	// mark of UTF-16 is passed through and stripped by the universal reader.
+	var dec transform.Transformer
+
+	switch encoding {
+	case EncodingLatin1:
+		dec = charmap.ISO8859_1.NewDecoder()
+	case EncodingWindows1252:
+		dec = charmap.Windows1252.NewDecoder()
+	case EncodingUTF16LE:
+		dec = unicode.UTF16(unicode.LittleEndian, unicode.IgnoreBOM).NewDecoder()
+	case EncodingUTF16BE:
+		dec = unicode.UTF16(unicode.BigEndian, unicode.IgnoreBOM).NewDecoder()
+	default:
+		// UTF-8 and ASCII are passed through as is.
+		return br, encoding, detected
+	}
+
+	return transform.NewReader(br, dec), encoding, detected
+}
@@ -0,0 +1,104 @@
+package validator
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+)
+
+func TestDetectEncoding(t *testing.T) {
+	tests := []struct {
+		In  []byte
+		Out string
+	}{
+		{[]byte("id,name\n1,Jane\n"), EncodingASCII},
+		{[]byte("\xef\xbb\xbfid,name\n"), EncodingUTF8},
+		{[]byte("id,name\n1,Zoë\n"), EncodingUTF8},
+		// Truncated multi-byte character at the end of the sample.
+		{[]byte("id,name\n1,Zo\xc3"), EncodingUTF8},
+		{[]byte("id,name\n1,Zo\xeb\n"), EncodingLatin1},
+		{[]byte("id,name\n1,\x93Jane\x94\n"), EncodingWindows1252},
+		{[]byte("\xff\xfei\x00d\x00"), EncodingUTF16LE},
+		{[]byte("\xfe\xff\x00i\x00d"), EncodingUTF16BE},
+		{[]byte("i\x00d\x00,\x00n\x00"), EncodingUTF16LE},
+		{[]byte("\x00i\x00d\x00,\x00n"), EncodingUTF16BE},
+	}
+
+	for i, test := range tests {
+		if out := detectEncoding(test.In); out != test.Out {
+			t.Errorf("[%d] expected %s, got %s", i, test.Out, out)
+		}
+	}
+}
+
+func TestDecoder(t *testing.T) {
+	tests := []struct {
+		Encoding string
+		In       []byte
+		Out      string
+		Used     string
+	}{
+		{EncodingUTF8, []byte("1,Zoë\n"), "1,Zoë\n", EncodingUTF8},
+		{EncodingLatin1, []byte("1,Zo\xeb\n"), "1,Zoë\n", EncodingLatin1},
+		{EncodingWindows1252, []byte("1,\x93Zo\xeb\x94 \x80\n"), "1,“Zoë” €\n", EncodingWindows1252},
+		{EncodingAuto, []byte("1,\x93Zo\xeb\x94\n"), "1,“Zoë”\n", EncodingWindows1252},
+		{EncodingAuto, []byte("1,Jane\n"), "1,Jane\n", EncodingUTF8},
+		// The byte order mark is passed through and stripped by the
+		// universal reader.
+		{EncodingUTF16, []byte("\xff\xfe1\x00,\x00\xeb\x00\n\x00"), "\ufeff1,ë\n", EncodingUTF16LE},
+		{EncodingAuto, []byte("\xfe\xff\x001\x00,\xd8\x3d\xde\x00"), "\ufeff1,😀", EncodingUTF16BE},
+		// Unpaired surrogates are replaced without dropping the next
+		// character.
+		{EncodingUTF16LE, []byte("1\x00\x3d\xd8,\x00\x00\xdc2\x00"), "1\ufffd,\ufffd2", EncodingUTF16LE},
+		{EncodingUTF16BE, []byte("\x001\xd8\x3d"), "1\ufffd", EncodingUTF16BE},
+		// Bytes undefined in Windows-1252 are replaced.
+		{EncodingWindows1252, []byte("a\x81b"), "a\ufffdb", EncodingWindows1252},
+		// A truncated code unit at the end of the input.
+		{EncodingUTF16LE, []byte("1\x00,"), "1\ufffd", EncodingUTF16LE},
+	}
+
+	for i, test := range tests {
+		r, used, _ := newDecoder(bytes.NewReader(test.In), test.Encoding)
+
+		b, err := ioutil.ReadAll(r)
+
+		if err != nil {
+			t.Errorf("[%d] unexpected error: %s", i, err)
+			continue
+		}
+
+		if string(b) != test.Out {
+			t.Errorf("[%d] expected %q, got %q", i, test.Out, string(b))
+		}
+
+		if used != test.Used {
+			t.Errorf("[%d] expected %s, got %s", i, test.Used, used)
+		}
+	}
+}
+
+func TestEncodingMismatch(t *testing.T) {
+	r := &Reader{Encoding: EncodingUTF8}
+
+	r.reader, r.Encoding, r.DetectedEncoding = newDecoder(bytes.NewBufferString("id,name\n1,Zo\xeb\n"), r.Encoding)
+
+	if !r.EncodingMismatch() {
+		t.Errorf("expected mismatch between %s and %s", r.Encoding, r.DetectedEncoding)
+	}
+
+	v := New(r, testTable(t, "person"), nil)
+
+	if errs := v.Result().TableErrors(); len(errs) != 1 || errs[0].Err != ErrEncodingMismatch {
+		t.Errorf("expected encoding mismatch table error, got %v", errs)
+	}
+}
+
+func TestParseEncoding(t *testing.T) {
+	if enc, err := ParseEncoding("CP1252"); err != nil || enc != EncodingWindows1252 {
+		t.Errorf("expected %s, got %s (%v)", EncodingWindows1252, enc, err)
+	}
+
+	if _, err := ParseEncoding("ebcdic"); err == nil {
+		t.Error("expected error for unknown encoding")
+	}
+}
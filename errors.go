@@ -1,6 +1,9 @@
 package validator
 
-import "fmt"
+import (
+	"fmt"
+	"strings"
+)
 
 // Error defines a specific type of error denoted by the description. A code is
 // defined as a shorthand for the error and to act as a lookup for the error itself.
@@ -22,6 +25,11 @@ var ErrBadEncoding = &Error{
 	Description: "UTF-8 encoding required",
 }
 
+var ErrEncodingMismatch = &Error{
+	Code:        101,
+	Description: "Content does not match the declared encoding",
+}
+
 var ErrBadHeader = &Error{
 	Code:        201,
 	Description: "Header does not contain the correct set of fields",
@@ -44,7 +52,37 @@ var ErrUnterminatedColumn = &Error{
 
 var ErrUnquotedColumn = &Error{
 	Code:        205,
-	Description: `Non-empty column must be quoted.`,
+	Description: `Column must be quoted.`,
+}
+
+var ErrRecordTooLong = &Error{
+	Code:        206,
+	Description: "Record exceeds the maximum size",
+}
+
+var ErrMixedLineEndings = &Error{
+	Code:        207,
+	Description: "Line ending differs from the rest of the file",
+}
+
+var ErrMissingRequiredColumns = &Error{
+	Code:        208,
+	Description: "Header is missing required columns",
+}
+
+var ErrDuplicateColumns = &Error{
+	Code:        209,
+	Description: "Header contains duplicate columns",
+}
+
+var ErrColumnOrder = &Error{
+	Code:        210,
+	Description: "Header columns are not in the order of the fields",
+}
+
+var ErrUnknownType = &Error{
+	Code:        211,
+	Description: "Field type is not known",
 }
 
 var ErrRequiredValue = &Error{
@@ -72,14 +110,64 @@ var ErrTypeMismatchNum = &Error{
 	Description: "Value is not a number (float32)",
 }
 
+var ErrTypeMismatchDecimal = &Error{
+	Code:        310,
+	Description: "Value is not a decimal",
+}
+
+var ErrTimezone = &Error{
+	Code:        311,
+	Description: "Datetime does not satisfy the timezone policy",
+}
+
+var ErrTypeMismatchTime = &Error{
+	Code:        312,
+	Description: "Value is not a time in an accepted layout",
+}
+
+var ErrTypeMismatchBool = &Error{
+	Code:        313,
+	Description: "Value is not a boolean",
+}
+
+var ErrTypeMismatchSmallInt = &Error{
+	Code:        314,
+	Description: "Value is not an integer (int16)",
+}
+
+var ErrTypeMismatchTinyInt = &Error{
+	Code:        315,
+	Description: "Value is not an integer (0-255)",
+}
+
+var ErrTypeMismatchUUID = &Error{
+	Code:        316,
+	Description: "Value is not a UUID",
+}
+
+var ErrDuplicatePrimaryKey = &Error{
+	Code:        317,
+	Description: "Primary key is not unique",
+}
+
+var ErrDuplicateUnique = &Error{
+	Code:        318,
+	Description: "Value violates a unique constraint",
+}
+
+var ErrOrphanReference = &Error{
+	Code:        319,
+	Description: "Referenced value does not exist",
+}
+
 var ErrTypeMismatchDate = &Error{
 	Code:        307,
-	Description: "Value is not a date (YYYY-MM-DD)",
+	Description: "Value is not a date in an accepted layout",
 }
 
 var ErrTypeMismatchDateTime = &Error{
 	Code:        308,
-	Description: "Value is not a datetime (YYYY-MM-DD HH:MM:SS)",
+	Description: "Value is not a datetime in an accepted layout",
 }
 
 var ErrLengthExceeded = &Error{
@@ -100,10 +188,19 @@ var ErrScaleExceeded = &Error{
 // Map of errors by code.
 var Errors = map[int]*Error{
 	100: ErrBadEncoding,
+	101: ErrEncodingMismatch,
 
 	201: ErrBadHeader,
 	202: ErrExtraColumns,
 	203: ErrBareQuote,
+	204: ErrUnterminatedColumn,
+	205: ErrUnquotedColumn,
+	206: ErrRecordTooLong,
+	207: ErrMixedLineEndings,
+	208: ErrMissingRequiredColumns,
+	209: ErrDuplicateColumns,
+	210: ErrColumnOrder,
+	211: ErrUnknownType,
 
 	300: ErrRequiredValue,
 	301: ErrTypeMismatch,
@@ -115,26 +212,50 @@ var Errors = map[int]*Error{
 	307: ErrTypeMismatchDate,
 	308: ErrTypeMismatchDateTime,
 	309: ErrTypeMismatchBigInt,
+	310: ErrTypeMismatchDecimal,
+	311: ErrTimezone,
+	312: ErrTypeMismatchTime,
+	313: ErrTypeMismatchBool,
+	314: ErrTypeMismatchSmallInt,
+	315: ErrTypeMismatchTinyInt,
+	316: ErrTypeMismatchUUID,
+	317: ErrDuplicatePrimaryKey,
+	318: ErrDuplicateUnique,
+	319: ErrOrphanReference,
 }
 
 // ValidationError is composed of an error with an optional line and
 // and field the error is specific to. Additional context can be supplied
-// in the context field.
+// in the context field. The line is the line the record starts on which
+// differs from the record number if records span multiple lines. The file
+// is set when the results of several files are merged. Errors of row
+// validators name the fields involved rather than a single field.
 type ValidationError struct {
 	Err     *Error
+	File    string
 	Line    int
+	Record  int
 	Field   string
+	Fields  []string
 	Value   string
 	Context Context
 }
 
 func (e ValidationError) Error() string {
-	var location string
+	location := fmt.Sprintf("line %d", e.Line)
 
-	if e.Field == "" {
-		location = fmt.Sprintf("line %d", e.Line)
-	} else {
-		location = fmt.Sprintf("line %d, field %s", e.Line, e.Field)
+	if e.File != "" {
+		location = fmt.Sprintf("%s: %s", e.File, location)
+	}
+
+	if e.Record != 0 && e.Record != e.Line {
+		location = fmt.Sprintf("%s (record %d)", location, e.Record)
+	}
+
+	if e.Field != "" {
+		location = fmt.Sprintf("%s, field %s", location, e.Field)
+	} else if len(e.Fields) > 0 {
+		location = fmt.Sprintf("%s, fields %s", location, strings.Join(e.Fields, ", "))
 	}
 
 	if e.Context != nil {
@@ -147,6 +268,12 @@ func (e ValidationError) Error() string {
 // Result maintains the validation results currently consisting of
 // validation errors.
 type Result struct {
+	// Encoding the input was decoded from.
+	Encoding string
+
+	// Errors that apply to the table as a whole rather than a line.
+	tableErrors []*ValidationError
+
 	lineErrors map[*Error][]*ValidationError
 
 	// field, grouped error code.
@@ -170,6 +297,16 @@ func (r *Result) LogError(verr *ValidationError) {
 	}
 }
 
+// LogTableError logs an error that applies to the table as a whole.
+func (r *Result) LogTableError(verr *ValidationError) {
+	r.tableErrors = append(r.tableErrors, verr)
+}
+
+// TableErrors returns the table errors.
+func (r *Result) TableErrors() []*ValidationError {
+	return r.tableErrors
+}
+
 // LineErrors returns the line errors.
 func (r *Result) LineErrors() map[*Error][]*ValidationError {
 	return r.lineErrors
@@ -180,6 +317,45 @@ func (r *Result) FieldErrors(f string) map[*Error][]*ValidationError {
 	return r.fieldErrors[f]
 }
 
+// Merge adds the errors of another result to the result. If file is not
+// empty, the errors are marked as being from the file.
+func (r *Result) Merge(o *Result, file string) {
+	mark := func(errs []*ValidationError) []*ValidationError {
+		if file == "" {
+			return errs
+		}
+
+		marked := make([]*ValidationError, len(errs))
+
+		for i, verr := range errs {
+			e := *verr
+			e.File = file
+			marked[i] = &e
+		}
+
+		return marked
+	}
+
+	r.tableErrors = append(r.tableErrors, mark(o.tableErrors)...)
+
+	for err, errs := range o.lineErrors {
+		r.lineErrors[err] = append(r.lineErrors[err], mark(errs)...)
+	}
+
+	for field, errmap := range o.fieldErrors {
+		errs, ok := r.fieldErrors[field]
+
+		if !ok {
+			errs = make(map[*Error][]*ValidationError)
+			r.fieldErrors[field] = errs
+		}
+
+		for err, verrs := range errmap {
+			errs[err] = append(errs[err], mark(verrs)...)
+		}
+	}
+}
+
 func NewResult() *Result {
 	return &Result{
 		lineErrors:  make(map[*Error][]*ValidationError),
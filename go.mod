@@ -1,10 +1,16 @@
 module github.com/chop-dbhi/data-models-validator
 
-go 1.16
+go 1.22
 
 require (
 	github.com/blang/semver v3.1.0+incompatible
 	github.com/chop-dbhi/data-models-service v0.0.0-20160330130915-8d1d167576d8
-	github.com/mattn/go-runewidth v0.0.1 // indirect
+	github.com/klauspost/compress v1.18.0
 	github.com/olekukonko/tablewriter v0.0.0-20160115111002-cca8bbc07984
+	github.com/pierrec/lz4/v4 v4.1.22
+	github.com/ulikunitz/xz v0.5.12
+	golang.org/x/text v0.22.0
+	gopkg.in/yaml.v3 v3.0.1
 )
+
+require github.com/mattn/go-runewidth v0.0.1 // indirect
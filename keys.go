@@ -0,0 +1,374 @@
+package validator
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/chop-dbhi/data-models-service/client"
+)
+
+// DefaultKeyMemory is the default number of bytes of keys held in memory
+// by a key checker before they are spilled to disk.
+const DefaultKeyMemory = 256 << 20
+
+// KeyConstraint is a primary key or unique constraint of a table.
+type KeyConstraint struct {
+	Name    string
+	Primary bool
+	Fields  []string
+}
+
+func (c *KeyConstraint) String() string {
+	return c.Name
+}
+
+// TableKeys returns the primary key and unique constraints of the table in
+// the schema of the model, the primary key first. Unique indexes are
+// included as unique constraints unless a constraint has the same fields.
+func TableKeys(model *client.Model, table string) []*KeyConstraint {
+	if model.Schema == nil {
+		return nil
+	}
+
+	var keys []*KeyConstraint
+
+	seen := make(map[string]bool)
+
+	add := func(name string, primary bool, fields []string) {
+		id := strings.ToLower(strings.Join(fields, ","))
+
+		if len(fields) == 0 || seen[id] {
+			return
+		}
+
+		seen[id] = true
+
+		keys = append(keys, &KeyConstraint{
+			Name:    name,
+			Primary: primary,
+			Fields:  fields,
+		})
+	}
+
+	var pks, uniques, indexes []string
+
+	for name, pk := range model.Schema.PrimaryKeys {
+		if strings.EqualFold(pk.Table, table) {
+			pks = append(pks, name)
+		}
+	}
+
+	for name, u := range model.Schema.Uniques {
+		if strings.EqualFold(u.Table, table) {
+			uniques = append(uniques, name)
+		}
+	}
+
+	for name, idx := range model.Schema.Indexes {
+		if idx.Unique && strings.EqualFold(idx.Table, table) {
+			indexes = append(indexes, name)
+		}
+	}
+
+	sort.Strings(pks)
+	sort.Strings(uniques)
+	sort.Strings(indexes)
+
+	for _, name := range pks {
+		add(name, true, model.Schema.PrimaryKeys[name].Fields)
+	}
+
+	for _, name := range uniques {
+		add(name, false, model.Schema.Uniques[name].Fields)
+	}
+
+	for _, name := range indexes {
+		add(name, false, model.Schema.Indexes[name].Fields)
+	}
+
+	return keys
+}
+
+// keyLocation is where a key occurs. The sequence orders the occurrences
+// across the inputs.
+type keyLocation struct {
+	seq  uint64
+	file int
+	line int
+}
+
+// keyDuplicate is a repeat of a key that first occurred elsewhere.
+type keyDuplicate struct {
+	key    int
+	value  string
+	first  keyLocation
+	repeat keyLocation
+}
+
+// keySet detects repeated values of a key. Values are held in memory up
+// to the limit of the checker, after which all values are written to
+// partition files and checked when the set is closed.
+type keySet struct {
+	seen    map[string]keyLocation
+	spilled *spillFiles
+}
+
+// KeyChecker checks the primary key and unique constraints of a table
+// across all of its inputs, such as the split parts of a table. Null values
+// are never equal, so keys with a null value are not checked.
+type KeyChecker struct {
+	Constraints []*KeyConstraint
+
+	// MaxMemory is the approximate number of bytes of keys held in memory
+	// before they are spilled to files in Dir. Defaults to DefaultKeyMemory.
+	MaxMemory int
+
+	// Dir is the directory keys are spilled to. Defaults to the temporary
+	// directory of the system.
+	Dir string
+
+	sets  []*keySet
+	size  int
+	seq   uint64
+	files inputFiles
+	dir   string
+	dups  []*keyDuplicate
+}
+
+// NewKeyChecker returns a key checker for the constraints.
+func NewKeyChecker(constraints []*KeyConstraint) *KeyChecker {
+	sets := make([]*keySet, len(constraints))
+
+	for i := range sets {
+		sets[i] = &keySet{seen: make(map[string]keyLocation)}
+	}
+
+	return &KeyChecker{
+		Constraints: constraints,
+		sets:        sets,
+	}
+}
+
+// Spilled returns true if keys have been spilled to disk.
+func (k *KeyChecker) Spilled() bool {
+	return k.dir != ""
+}
+
+// encodeKey encodes the values of a key such that distinct values have
+// distinct encodings. The values are copied since they may share the
+// memory of the record.
+func encodeKey(values []string) string {
+	if len(values) == 1 {
+		return strings.Clone(values[0])
+	}
+
+	var b strings.Builder
+
+	for _, v := range values {
+		b.WriteString(strconv.Itoa(len(v)))
+		b.WriteByte(':')
+		b.WriteString(v)
+	}
+
+	return b.String()
+}
+
+// add adds the key values of the constraint at the line of the file.
+func (k *KeyChecker) add(i int, values []string, file string, line int) error {
+	k.seq++
+
+	loc := keyLocation{
+		seq:  k.seq,
+		file: k.files.index(file),
+		line: line,
+	}
+
+	key := encodeKey(values)
+	s := k.sets[i]
+
+	if s.spilled != nil {
+		return s.spilled.write(key, loc, 1)
+	}
+
+	if first, ok := s.seen[key]; ok {
+		k.dups = append(k.dups, &keyDuplicate{
+			key:    i,
+			value:  key,
+			first:  first,
+			repeat: loc,
+		})
+
+		return nil
+	}
+
+	s.seen[key] = loc
+	k.size += len(key) + spillOverhead
+
+	max := k.MaxMemory
+
+	if max <= 0 {
+		max = DefaultKeyMemory
+	}
+
+	if k.size > max {
+		return k.spill()
+	}
+
+	return nil
+}
+
+// spill writes the keys held in memory to partition files.
+func (k *KeyChecker) spill() error {
+	if k.dir == "" {
+		dir, err := os.MkdirTemp(k.Dir, "keys")
+
+		if err != nil {
+			return err
+		}
+
+		k.dir = dir
+	}
+
+	for i, s := range k.sets {
+		if s.spilled == nil {
+			var err error
+
+			if s.spilled, err = newSpillFiles(k.dir, fmt.Sprint(i)); err != nil {
+				return err
+			}
+		}
+
+		for key, loc := range s.seen {
+			if err := s.spilled.write(key, loc, 1); err != nil {
+				return err
+			}
+		}
+
+		s.seen = make(map[string]keyLocation)
+	}
+
+	k.size = 0
+
+	return nil
+}
+
+// check reads the partition files and returns the repeated keys. Keys
+// that were held in memory when spilled precede later occurrences in
+// their partition, so the first occurrence is read first.
+func (s *keySet) check(i int) ([]*keyDuplicate, error) {
+	var dups []*keyDuplicate
+
+	for j := 0; j < spillPartitions; j++ {
+		seen := make(map[string]keyLocation)
+
+		err := s.spilled.read(j, func(key string, loc keyLocation, _ int) {
+			if first, ok := seen[key]; ok {
+				dups = append(dups, &keyDuplicate{
+					key:    i,
+					value:  key,
+					first:  first,
+					repeat: loc,
+				})
+			} else {
+				seen[key] = loc
+			}
+		})
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return dups, nil
+}
+
+// Close logs the repeated keys to the result in the order they occurred
+// and removes the spilled files. The file of the errors is set if the
+// keys of more than one file were checked.
+func (k *KeyChecker) Close(result *Result) error {
+	dups := k.dups
+
+	if k.dir != "" {
+		defer os.RemoveAll(k.dir)
+
+		for i, s := range k.sets {
+			sd, err := s.check(i)
+			s.spilled.close()
+
+			if err != nil {
+				return err
+			}
+
+			dups = append(dups, sd...)
+		}
+
+		sort.Slice(dups, func(i, j int) bool {
+			return dups[i].repeat.seq < dups[j].repeat.seq
+		})
+	}
+
+	location := func(loc keyLocation) (string, string) {
+		if len(k.files) < 2 {
+			return "", fmt.Sprintf("line %d", loc.line)
+		}
+
+		file := k.files[loc.file]
+
+		return file, fmt.Sprintf("%s line %d", file, loc.line)
+	}
+
+	for _, d := range dups {
+		c := k.Constraints[d.key]
+
+		err := ErrDuplicateUnique
+
+		if c.Primary {
+			err = ErrDuplicatePrimaryKey
+		}
+
+		file, _ := location(d.repeat)
+		_, first := location(d.first)
+
+		result.LogError(&ValidationError{
+			Err:    err,
+			File:   file,
+			Line:   d.repeat.line,
+			Fields: c.Fields,
+			Value:  keyValue(c.Fields, d.value),
+			Context: Context{
+				"constraint": c.Name,
+				"first":      first,
+			},
+		})
+	}
+
+	k.dups = nil
+
+	return nil
+}
+
+// keyValue formats the encoded key as field=value pairs.
+func keyValue(fields []string, key string) string {
+	if len(fields) == 1 {
+		return fmt.Sprintf("%s=%s", fields[0], key)
+	}
+
+	values := make([]string, 0, len(fields))
+
+	for _, f := range fields {
+		i := strings.IndexByte(key, ':')
+
+		if i < 0 {
+			break
+		}
+
+		n, _ := strconv.Atoi(key[:i])
+		values = append(values, fmt.Sprintf("%s=%s", f, key[i+1:i+1+n]))
+		key = key[i+1+n:]
+	}
+
+	return strings.Join(values, ", ")
+}
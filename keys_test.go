@@ -0,0 +1,137 @@
+package validator
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"testing"
+
+	dms "github.com/chop-dbhi/data-models-service/client"
+)
+
+func testKeyModel() *dms.Model {
+	s := &dms.Schema{}
+
+	s.AddPrimaryKey(dms.Attrs{"name": "visit_pkey", "table": "visit", "field": "visit_id"})
+	s.AddUnique(dms.Attrs{"name": "visit_source", "table": "visit", "field": "value_as_string"})
+	s.AddUnique(dms.Attrs{"name": "visit_source", "table": "visit", "field": "value_as_number"})
+	s.AddIndex(dms.Attrs{"name": "idx_visit", "table": "visit", "field": "visit_id", "unique": "yes"})
+	s.AddIndex(dms.Attrs{"name": "idx_start", "table": "visit", "field": "visit_start_date"})
+	s.AddPrimaryKey(dms.Attrs{"name": "person_pkey", "table": "person", "field": "person_id"})
+
+	return &dms.Model{Schema: s}
+}
+
+func TestTableKeys(t *testing.T) {
+	keys := TableKeys(testKeyModel(), "VISIT")
+
+	if len(keys) != 2 {
+		t.Fatalf("expected 2 keys, got %v", keys)
+	}
+
+	if keys[0].Name != "visit_pkey" || !keys[0].Primary {
+		t.Errorf("expected primary key first, got %v", keys[0])
+	}
+
+	if keys[1].Primary || fmt.Sprint(keys[1].Fields) != "[value_as_string value_as_number]" {
+		t.Errorf("wrong unique constraint %v", keys[1].Fields)
+	}
+
+	if keys := TableKeys(&dms.Model{}, "visit"); keys != nil {
+		t.Errorf("expected no keys without a schema, got %v", keys)
+	}
+}
+
+func TestKeyChecker(t *testing.T) {
+	parts := []string{
+		"visit_id,value_as_number,value_as_string\n1,1,a\n2,1,b\n1,2,a\n,3,c\n",
+		"visit_id,value_as_number,value_as_string\n3,2,a\n2,1,a\n,,\n",
+	}
+
+	for _, max := range []int{0, 1} {
+		k := NewKeyChecker(TableKeys(testKeyModel(), "visit"))
+		k.MaxMemory = max
+		k.Dir = t.TempDir()
+
+		result := NewResult()
+
+		for i, part := range parts {
+			v := New(bytes.NewBufferString(part), testVisitTable(), nil)
+			v.Lenient = true
+			v.Keys = k
+			v.File = fmt.Sprintf("visit_%d.csv", i+1)
+
+			if err := v.Init(); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := v.Run(); err != nil {
+				t.Fatal(err)
+			}
+
+			result.Merge(v.Result(), v.File)
+		}
+
+		if k.Spilled() != (max == 1) {
+			t.Errorf("[%d] expected spilled %v", max, max == 1)
+		}
+
+		if err := k.Close(result); err != nil {
+			t.Fatal(err)
+		}
+
+		var pks []string
+
+		for _, verr := range result.LineErrors()[ErrDuplicatePrimaryKey] {
+			pks = append(pks, fmt.Sprintf("%s %d %s %s", verr.File, verr.Line, verr.Value, verr.Context["first"]))
+		}
+
+		expected := "[visit_1.csv 4 visit_id=1 visit_1.csv line 2 visit_2.csv 3 visit_id=2 visit_1.csv line 3]"
+
+		if fmt.Sprint(pks) != expected {
+			t.Errorf("[%d] wrong primary key errors %v", max, pks)
+		}
+
+		var uniques []string
+
+		for _, verr := range result.LineErrors()[ErrDuplicateUnique] {
+			uniques = append(uniques, fmt.Sprintf("%d %s %s", verr.Line, verr.Value, verr.Context["first"]))
+		}
+
+		expected = "[2 value_as_string=a, value_as_number=2 visit_1.csv line 4 3 value_as_string=a, value_as_number=1 visit_1.csv line 2]"
+
+		if fmt.Sprint(uniques) != expected {
+			t.Errorf("[%d] wrong unique errors %v", max, uniques)
+		}
+
+		if entries, _ := os.ReadDir(k.Dir); len(entries) != 0 {
+			t.Errorf("[%d] expected spilled keys to be removed", max)
+		}
+	}
+
+	// Files are only named if keys of several files are checked.
+	k := NewKeyChecker(TableKeys(testKeyModel(), "visit"))
+
+	v := New(bytes.NewBufferString("visit_id\n1\n1\n"), testVisitTable(), nil)
+	v.Lenient = true
+	v.Keys = k
+	v.File = "visit.csv"
+
+	if err := v.Init(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := v.Run(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := k.Close(v.Result()); err != nil {
+		t.Fatal(err)
+	}
+
+	errs := v.Result().LineErrors()[ErrDuplicatePrimaryKey]
+
+	if len(errs) != 1 || errs[0].File != "" || errs[0].Line != 3 || errs[0].Context["first"] != "line 2" {
+		t.Errorf("wrong errors %v", errs)
+	}
+}
@@ -0,0 +1,316 @@
+package validator
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+// ColumnMapping maps a column in the header of a file to a field. If the
+// table is empty, the mapping applies to all tables.
+type ColumnMapping struct {
+	Table  string
+	Column string
+	Field  string
+}
+
+// HeaderMapping is a set of column mappings applied to the header before
+// it is matched against the fields of the table.
+type HeaderMapping []*ColumnMapping
+
+// Field returns the field the column of the table is mapped to. Table
+// specific mappings take precedence. If the column is not mapped, an empty
+// string is returned. Names are compared case-insensitively.
+func (m HeaderMapping) Field(table, column string) string {
+	var field string
+
+	for _, c := range m {
+		if !strings.EqualFold(c.Column, column) {
+			continue
+		}
+
+		if strings.EqualFold(c.Table, table) {
+			return strings.ToLower(c.Field)
+		}
+
+		if c.Table == "" {
+			field = strings.ToLower(c.Field)
+		}
+	}
+
+	return field
+}
+
+// Aliases returns the columns mapped to the field of the table.
+func (m HeaderMapping) Aliases(table, field string) []string {
+	var aliases []string
+
+	for _, c := range m {
+		if (c.Table == "" || strings.EqualFold(c.Table, table)) && strings.EqualFold(c.Field, field) {
+			aliases = append(aliases, strings.ToLower(c.Column))
+		}
+	}
+
+	return aliases
+}
+
+// Columns returns the columns mapped for the table in the order they are
+// defined. Mappings that apply to all tables are not included. This is the
+// column order of inputs without a header.
+func (m HeaderMapping) Columns(table string) []string {
+	var cols []string
+
+	for _, c := range m {
+		if c.Table != "" && strings.EqualFold(c.Table, table) {
+			cols = append(cols, c.Column)
+		}
+	}
+
+	return cols
+}
+
+// ReadHeaderMapping reads a mapping file. The file is a CSV file with the
+// columns table, column and field. The table column is optional.
+func ReadHeaderMapping(r io.Reader) (HeaderMapping, error) {
+	cr := csv.NewReader(r)
+	cr.TrimLeadingSpace = true
+	cr.FieldsPerRecord = -1
+
+	head, err := cr.Read()
+
+	if err != nil {
+		if err == io.EOF {
+			return nil, nil
+		}
+
+		return nil, err
+	}
+
+	index := map[string]int{
+		"table":  -1,
+		"column": -1,
+		"field":  -1,
+	}
+
+	for i, c := range head {
+		c = strings.ToLower(strings.TrimSpace(c))
+
+		if _, ok := index[c]; ok {
+			index[c] = i
+		}
+	}
+
+	if index["column"] == -1 || index["field"] == -1 {
+		return nil, fmt.Errorf("mapping requires a column and field column")
+	}
+
+	get := func(row []string, c string) string {
+		if i := index[c]; i >= 0 && i < len(row) {
+			return strings.TrimSpace(row[i])
+		}
+
+		return ""
+	}
+
+	var m HeaderMapping
+
+	for {
+		row, err := cr.Read()
+
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		c := &ColumnMapping{
+			Table:  get(row, "table"),
+			Column: get(row, "column"),
+			Field:  get(row, "field"),
+		}
+
+		// Columns without a field are placeholders in starter files.
+		if c.Column == "" || c.Field == "" {
+			continue
+		}
+
+		m = append(m, c)
+	}
+
+	return m, nil
+}
+
+// OpenHeaderMapping reads a mapping file by name.
+func OpenHeaderMapping(name string) (HeaderMapping, error) {
+	f, err := os.Open(name)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer f.Close()
+
+	m, err := ReadHeaderMapping(f)
+
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", name, err)
+	}
+
+	return m, nil
+}
+
+// Write writes the mapping as a CSV file that can be read by ReadHeaderMapping.
+func (m HeaderMapping) Write(w io.Writer) error {
+	cw := csv.NewWriter(w)
+
+	if err := cw.Write([]string{"table", "column", "field"}); err != nil {
+		return err
+	}
+
+	for _, c := range m {
+		if err := cw.Write([]string{c.Table, c.Column, c.Field}); err != nil {
+			return err
+		}
+	}
+
+	cw.Flush()
+
+	return cw.Error()
+}
+
+// Suffixes of column names that are commonly used in place of each other.
+var aliasSuffixes = [][2]string{
+	{"_source_id", "_source_value"},
+	{"_id", "_concept_id"},
+	{"_dt", "_date"},
+	{"_dttm", "_datetime"},
+	{"_ts", "_datetime"},
+	{"_tm", "_time"},
+}
+
+// sharePrefix returns true if the tokens of the shorter name are the
+// leading tokens of the other, such as visit and visit_occurrence.
+func sharePrefix(a, b string) bool {
+	if len(a) > len(b) {
+		a, b = b, a
+	}
+
+	return a != "" && (a == b || strings.HasPrefix(b, a+"_"))
+}
+
+// isAlias returns true if the column is a known alias of the field. This
+// includes columns mapped to the field, columns with a vendor prefix and
+// columns using an equivalent suffix whose names share their leading
+// tokens.
+func isAlias(column, field string, aliases []string) bool {
+	for _, a := range aliases {
+		if a == column {
+			return true
+		}
+	}
+
+	if strings.HasSuffix(column, "_"+field) {
+		return true
+	}
+
+	for _, s := range aliasSuffixes {
+		for _, p := range [][2]string{s, {s[1], s[0]}} {
+			if strings.HasSuffix(column, p[0]) && strings.HasSuffix(field, p[1]) &&
+				sharePrefix(strings.TrimSuffix(column, p[0]), strings.TrimSuffix(field, p[1])) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// suggestFields returns the closest candidate field names for the column
+// ordered by relevance. Known aliases are suggested first followed by
+// fields within a small edit distance.
+func suggestFields(column string, candidates []string, mapping HeaderMapping, table string) []string {
+	type match struct {
+		field string
+		dist  int
+	}
+
+	var matches []match
+
+	column = strings.ToLower(column)
+
+	for _, f := range candidates {
+		if isAlias(column, f, mapping.Aliases(table, f)) {
+			matches = append(matches, match{f, -1})
+			continue
+		}
+
+		max := len(f) / 3
+
+		if max < 2 {
+			max = 2
+		}
+
+		if d := editDistance(column, f); d <= max {
+			matches = append(matches, match{f, d})
+		}
+	}
+
+	sort.SliceStable(matches, func(i, j int) bool {
+		return matches[i].dist < matches[j].dist
+	})
+
+	fields := make([]string, len(matches))
+
+	for i, m := range matches {
+		fields[i] = m.field
+	}
+
+	return fields
+}
+
+// editDistance returns the Levenshtein distance between two strings.
+func editDistance(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+
+	prev := make([]int, len(rb)+1)
+	curr := make([]int, len(rb)+1)
+
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(ra); i++ {
+		curr[0] = i
+
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+
+			curr[j] = min3(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+
+		prev, curr = curr, prev
+	}
+
+	return prev[len(rb)]
+}
+
+func min3(a, b, c int) int {
+	if b < a {
+		a = b
+	}
+
+	if c < a {
+		a = c
+	}
+
+	return a
+}
@@ -0,0 +1,102 @@
+package validator
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+)
+
+func TestReadHeaderMapping(t *testing.T) {
+	input := `table,column,field
+visit_occurrence,VISIT_OCCURRENCE_SOURCE_ID,visit_source_value
+,pat_id,person_id
+person,pat_id,person_source_value
+,unmapped,
+`
+
+	m, err := ReadHeaderMapping(bytes.NewBufferString(input))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(m) != 3 {
+		t.Fatalf("expected 3 mappings, got %d", len(m))
+	}
+
+	tests := []struct {
+		Table  string
+		Column string
+		Field  string
+	}{
+		{"visit_occurrence", "visit_occurrence_source_id", "visit_source_value"},
+		{"visit_occurrence", "pat_id", "person_id"},
+		{"person", "pat_id", "person_source_value"},
+		{"person", "unmapped", ""},
+	}
+
+	for i, test := range tests {
+		if f := m.Field(test.Table, test.Column); f != test.Field {
+			t.Errorf("[%d] expected %s, got %s", i, test.Field, f)
+		}
+	}
+
+	var buf bytes.Buffer
+
+	if err = m.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	if m2, _ := ReadHeaderMapping(&buf); len(m2) != len(m) {
+		t.Errorf("expected %d mappings after round trip, got %d", len(m), len(m2))
+	}
+}
+
+func TestSuggestFields(t *testing.T) {
+	missing := []string{"visit_source_value", "person_id", "visit_start_date"}
+
+	tests := []struct {
+		Column string
+		Fields []string
+	}{
+		{"visit_source_id", []string{"visit_source_value"}},
+		{"visit_occurrence_source_id", []string{"visit_source_value"}},
+		{"persn_id", []string{"person_id"}},
+		{"site_person_id", []string{"person_id"}},
+		{"visit_start_dt", []string{"visit_start_date"}},
+		{"provider_id", nil},
+	}
+
+	for i, test := range tests {
+		fields := suggestFields(test.Column, missing, nil, "visit_occurrence")
+
+		if fmt.Sprint(fields) != fmt.Sprint(test.Fields) {
+			t.Errorf("[%d] expected %v, got %v", i, test.Fields, fields)
+		}
+	}
+
+	// Aliases defined in the mapping are suggested.
+	m := HeaderMapping{{Column: "enc_id", Field: "visit_source_value"}}
+
+	if fields := suggestFields("enc_id", missing, m, "visit_occurrence"); fmt.Sprint(fields) != "[visit_source_value]" {
+		t.Errorf("expected alias suggestion, got %v", fields)
+	}
+}
+
+func TestEditDistance(t *testing.T) {
+	tests := []struct {
+		A, B string
+		D    int
+	}{
+		{"", "abc", 3},
+		{"kitten", "sitting", 3},
+		{"person_id", "person_id", 0},
+		{"persn_id", "person_id", 1},
+	}
+
+	for _, test := range tests {
+		if d := editDistance(test.A, test.B); d != test.D {
+			t.Errorf("%s -> %s: expected %d, got %d", test.A, test.B, test.D, d)
+		}
+	}
+}
@@ -1,51 +1,103 @@
 package validator
 
 import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
 	"bytes"
 	"compress/bzip2"
 	"compress/gzip"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
-	"path/filepath"
+	"path"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/pierrec/lz4/v4"
+	"github.com/ulikunitz/xz"
 )
 
 var bom = []byte{0xef, 0xbb, 0xbf}
 
-func detectCompression(name string) string {
-	switch filepath.Ext(name) {
-	case ".gzip", ".gz":
-		return "gzip"
-	case ".bzip2", ".bz2":
+// Magic bytes at the start of compressed data and archives.
+var magicBytes = []struct {
+	compr string
+	magic []byte
+}{
+	{"gzip", []byte{0x1f, 0x8b}},
+	{"xz", []byte{0xfd, '7', 'z', 'X', 'Z', 0x00}},
+	{"zstd", []byte{0x28, 0xb5, 0x2f, 0xfd}},
+	{"lz4", []byte{0x04, 0x22, 0x4d, 0x18}},
+	{"zip", []byte("PK\x03\x04")},
+	{"zip", []byte("PK\x05\x06")},
+}
+
+// Offset of the magic bytes of a tar archive.
+const tarMagicOffset = 257
+
+// sniffCompression detects the compression method or archive format from
+// the magic bytes at the start of the data.
+func sniffCompression(br *bufio.Reader) string {
+	b, _ := br.Peek(tarMagicOffset + 5)
+
+	for _, m := range magicBytes {
+		if bytes.HasPrefix(b, m.magic) {
+			return m.compr
+		}
+	}
+
+	// The bzip2 magic is followed by the block size from 1 to 9.
+	if len(b) >= 4 && bytes.HasPrefix(b, []byte("BZh")) && b[3] >= '1' && b[3] <= '9' {
 		return "bzip2"
 	}
 
+	if len(b) == tarMagicOffset+5 && string(b[tarMagicOffset:]) == "ustar" {
+		return "tar"
+	}
+
 	return ""
 }
 
-// UniversalReader wraps an io.Reader to replace carriage returns with newlines.
-// This is used with the csv.Reader so it can properly delimit lines.
+func isArchive(compr string) bool {
+	return compr == "zip" || compr == "tar"
+}
+
+// UniversalReader wraps an io.Reader to remove the byte order mark from the
+// start of the stream. Line endings are handled by the CSVReader.
 type UniversalReader struct {
 	r io.Reader
+
+	// Set once the start of the stream has been checked and the bytes
+	// read while checking that are not part of a BOM.
+	checked bool
+	head    []byte
 }
 
 func (r *UniversalReader) Read(buf []byte) (int, error) {
-	n, err := r.r.Read(buf)
+	// Detect and remove BOM at the start of the stream.
+	if !r.checked {
+		r.checked = true
 
-	// Detect and remove BOM.
-	if bytes.HasPrefix(buf, bom) {
-		copy(buf, buf[len(bom):])
-		n -= len(bom)
-	}
+		head := make([]byte, len(bom))
+		n, err := io.ReadFull(r.r, head)
+
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return 0, err
+		}
 
-	// Replace carriage returns with newlines
-	for i, b := range buf {
-		if b == '\r' {
-			buf[i] = '\n'
+		if !bytes.Equal(head[:n], bom) {
+			r.head = head[:n]
 		}
 	}
 
-	return n, err
+	if len(r.head) > 0 {
+		n := copy(buf, r.head)
+		r.head = r.head[n:]
+		return n, nil
+	}
+
+	return r.r.Read(buf)
 }
 
 // Reader encapsulates a stdin stream.
@@ -53,8 +105,19 @@ type Reader struct {
 	Name        string
 	Compression string
 
+	// Path of the file within the archive if the input is an archive.
+	Entry string
+
+	// Encoding the input is transcoded from and the encoding detected
+	// from a sample of the input.
+	Encoding         string
+	DetectedEncoding string
+
 	reader io.Reader
 	file   *os.File
+
+	// Decompressor that must be closed.
+	closer io.Closer
 }
 
 // Read implements the io.Reader interface.
@@ -62,60 +125,291 @@ func (r *Reader) Read(buf []byte) (int, error) {
 	return r.reader.Read(buf)
 }
 
+// EncodingMismatch returns true if the content of the input does not
+// match the encoding it is decoded as.
+func (r *Reader) EncodingMismatch() bool {
+	return !encodingsCompatible(r.Encoding, r.DetectedEncoding)
+}
+
 // Close implements the io.Closer interface.
 func (r *Reader) Close() {
+	if r.closer != nil {
+		r.closer.Close()
+	}
+
 	if r.file != nil {
 		r.file.Close()
 	}
 }
 
-// Open a reader by name with optional compression. If no name is specified, STDIN
-// is used.
-func Open(name, compr string) (*Reader, error) {
-	r := new(Reader)
+// InputFunc is called for each input read by ReadInputs. If the input could
+// not be opened, the error is passed with a reader that only has the name and
+// entry set. If an error is returned, no more inputs are read.
+type InputFunc func(r *Reader, err error) error
 
-	if compr == "" {
-		compr = detectCompression(name)
+// openFile opens the file by name. If no name is specified, STDIN is used.
+func openFile(name string) (io.Reader, *os.File, error) {
+	if name == "" {
+		return os.Stdin, nil, nil
+	}
+
+	file, err := os.Open(name)
+
+	if err != nil {
+		return nil, nil, err
 	}
 
+	return file, file, nil
+}
+
+// decompress applies the decompressor to the data. If the compression is not
+// specified, it is detected from the magic bytes. If the data is an archive,
+// the archive format is returned and the data is returned as is.
+func decompress(src io.Reader, compr string) (io.Reader, string, io.Closer, error) {
 	// Validate Compressionession method before working with files.
 	switch compr {
-	case "bzip2", "gzip", "":
+	case "bzip2", "gzip", "xz", "zstd", "lz4", "zip", "tar", "":
 	default:
-		return nil, fmt.Errorf("unknown compression type %s", compr)
+		return nil, "", nil, fmt.Errorf("unknown compression type %s", compr)
 	}
 
-	if name == "" {
-		r.reader = os.Stdin
-	} else {
-		file, err := os.Open(name)
+	br := bufio.NewReader(src)
 
-		if err != nil {
-			return nil, err
-		}
-
-		r.file = file
-		r.reader = file
+	if compr == "" {
+		compr = sniffCompression(br)
 	}
 
+	var (
+		rd     io.Reader = br
+		closer io.Closer
+	)
+
 	// Apply the Compressionession decoder.
 	switch compr {
 	case "gzip":
-		reader, err := gzip.NewReader(r.reader)
+		reader, err := gzip.NewReader(br)
 
 		if err != nil {
-			r.Close()
-			return nil, err
+			return nil, compr, nil, err
 		}
 
-		r.reader = reader
+		rd, closer = reader, reader
 	case "bzip2":
-		r.reader = bzip2.NewReader(r.reader)
+		rd = bzip2.NewReader(br)
+	case "xz":
+		reader, err := xz.NewReader(br)
+
+		if err != nil {
+			return nil, compr, nil, err
+		}
+
+		rd = reader
+	case "zstd":
+		reader, err := zstd.NewReader(br)
+
+		if err != nil {
+			return nil, compr, nil, err
+		}
+
+		rd, closer = reader, reader.IOReadCloser()
+	case "lz4":
+		rd = lz4.NewReader(br)
+	case "zip", "tar", "":
+		return br, compr, nil, nil
 	}
 
-	r.Compression = compr
+	// Compressed tar archive.
+	br = bufio.NewReader(rd)
 
-	r.reader = &UniversalReader{r.reader}
+	if sniffCompression(br) == "tar" {
+		return br, "tar", closer, nil
+	}
+
+	return br, compr, closer, nil
+}
+
+// Open a reader by name with optional compression. If no name is specified, STDIN
+// is used. If no compression is specified, it is detected from the data. The input
+// is transcoded from the encoding to UTF-8. If the encoding is auto, it is detected
+// from the byte order mark or a sample of the input. Archives are not supported,
+// use ReadInputs instead.
+func Open(name, compr, encoding string) (*Reader, error) {
+	encoding, err := ParseEncoding(encoding)
+
+	if err != nil {
+		return nil, err
+	}
+
+	src, file, err := openFile(name)
+
+	if err != nil {
+		return nil, err
+	}
+
+	rd, compr, closer, err := decompress(src, compr)
+
+	r := &Reader{
+		Name:        name,
+		Compression: compr,
+		file:        file,
+		closer:      closer,
+	}
+
+	if err == nil && isArchive(compr) {
+		err = fmt.Errorf("%s is a %s archive", name, compr)
+	}
+
+	if err != nil {
+		r.Close()
+		return nil, err
+	}
+
+	r.reader, r.Encoding, r.DetectedEncoding = newDecoder(rd, encoding)
+	r.reader = &UniversalReader{r: r.reader}
 
 	return r, nil
 }
+
+// ReadInputs opens the input by name and calls fn with a reader for the input.
+// If the input is a zip or tar archive, fn is called for each file in the archive
+// instead. Compressed files in the archive are decompressed. The reader is closed
+// once fn returns. See Open for the handling of the name, compression and encoding.
+func ReadInputs(name, compr, encoding string, fn InputFunc) error {
+	encoding, err := ParseEncoding(encoding)
+
+	if err != nil {
+		return fn(&Reader{Name: name}, err)
+	}
+
+	src, file, err := openFile(name)
+
+	if err != nil {
+		return fn(&Reader{Name: name}, err)
+	}
+
+	defer file.Close()
+
+	return readInputs(name, "", src, compr, encoding, fn)
+}
+
+func readInputs(name, entry string, src io.Reader, compr, encoding string, fn InputFunc) error {
+	rd, compr, closer, err := decompress(src, compr)
+
+	if err != nil {
+		return fn(&Reader{Name: name, Entry: entry}, err)
+	}
+
+	switch compr {
+	case "zip":
+		return readZip(name, entry, src, rd, encoding, fn)
+	case "tar":
+		err = readTar(name, entry, rd, encoding, fn)
+
+		if closer != nil {
+			closer.Close()
+		}
+
+		return err
+	}
+
+	r := &Reader{
+		Name:        name,
+		Entry:       entry,
+		Compression: compr,
+		closer:      closer,
+	}
+
+	r.reader, r.Encoding, r.DetectedEncoding = newDecoder(rd, encoding)
+	r.reader = &UniversalReader{r: r.reader}
+
+	defer r.Close()
+
+	return fn(r, nil)
+}
+
+func readTar(name, prefix string, rd io.Reader, encoding string, fn InputFunc) error {
+	tr := tar.NewReader(rd)
+
+	for {
+		hdr, err := tr.Next()
+
+		if err == io.EOF {
+			return nil
+		}
+
+		if err != nil {
+			return fn(&Reader{Name: name, Entry: prefix}, err)
+		}
+
+		if !hdr.FileInfo().Mode().IsRegular() {
+			continue
+		}
+
+		if err = readInputs(name, path.Join(prefix, hdr.Name), tr, "", encoding, fn); err != nil {
+			return err
+		}
+	}
+}
+
+// readZip reads the entries of a zip archive which requires random access.
+// Unless the archive is a file, it is copied to a temporary file first.
+func readZip(name, prefix string, src, rd io.Reader, encoding string, fn InputFunc) error {
+	file, ok := src.(*os.File)
+
+	// The file is only used as is if nothing has been read from it.
+	if !ok || file == os.Stdin || prefix != "" {
+		tmp, err := ioutil.TempFile("", "data-models-validator-*.zip")
+
+		if err != nil {
+			return fn(&Reader{Name: name, Entry: prefix}, err)
+		}
+
+		defer os.Remove(tmp.Name())
+		defer tmp.Close()
+
+		if _, err = io.Copy(tmp, rd); err != nil {
+			return fn(&Reader{Name: name, Entry: prefix}, err)
+		}
+
+		file = tmp
+	}
+
+	info, err := file.Stat()
+
+	if err != nil {
+		return fn(&Reader{Name: name, Entry: prefix}, err)
+	}
+
+	zr, err := zip.NewReader(file, info.Size())
+
+	if err != nil {
+		return fn(&Reader{Name: name, Entry: prefix}, err)
+	}
+
+	for _, f := range zr.File {
+		if f.FileInfo().IsDir() {
+			continue
+		}
+
+		entry := path.Join(prefix, f.Name)
+
+		rc, err := f.Open()
+
+		if err != nil {
+			if err = fn(&Reader{Name: name, Entry: entry}, err); err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		err = readInputs(name, entry, rc, "", encoding, fn)
+		rc.Close()
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -1,34 +1,231 @@
 package validator
 
 import (
+	"archive/tar"
+	"archive/zip"
 	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
 	"testing"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
 )
 
 func TestUniversalReader(t *testing.T) {
-	s := "\xef\xbb\xbfhello world!\r"
+	tests := map[string]string{
+		"\xef\xbb\xbfhello world!\r\n":     "hello world!\r\n",
+		"hello\r\"world\"\r":               "hello\r\"world\"\r",
+		"he\xef\xbb\xbfllo":                "he\xef\xbb\xbfllo",
+		"\xef\xbb":                         "\xef\xbb",
+		"\xef\xbb\xbfa,b\n\xef\xbb\xbfc,d": "a,b\n\xef\xbb\xbfc,d",
+	}
+
+	for s, exp := range tests {
+		ur := &UniversalReader{r: bytes.NewBufferString(s)}
+
+		b, err := ioutil.ReadAll(ur)
+
+		if err != nil {
+			t.Fatalf("problem reading: %s", err)
+		}
+
+		if string(b) != exp {
+			t.Errorf("expected %q, got %q", exp, string(b))
+		}
+	}
+}
+
+const readerTestData = "person_id,birth_date\n1,2000-01-01\n"
+
+// lz4 -BD --content-size of 40 rows of person data (572 bytes).
+const lz4TestFrame = "BCJNGGxAPAIAAAAAAAA8MAEAAPYUcGVyc29uX2lkLGJpcnRoX2RhdGUKMSwyMDAwLTAxLTAyCjINADYzCjMNADY0CjQNADY1CjUNADY2CjYNADY3CjcNADY4CjgNADU5CjkNAFYxMAoxMA4ANjEKMYQARjEyCjGFAEYxMwoxhgBGMTQKMYcARjE1CjGIAEYxNgoxiQBGMTcKMYoARjE4CjGLAEYxOQoxjABGMjAKMowARjIxCjKMAEYyMgoyjABGMjMKMowARjI0CjKMAEYyNQoyjABGMjYKMowARjI3CjKMAEYyOAoyjABGMDEKMowARjAyCjMYAUYwMwozjABGMDQKM4wARjA1CjOMAEYwNgozjABGMDcKM4wARjA4CjOMAEYwOQozjABGMTAKM4wARjExCjOMAEQxMgo0jABQMS0xMwoAAAAACg+Yrg=="
+
+func compressTestData(t *testing.T, compr string, data []byte) []byte {
+	var buf bytes.Buffer
+
+	var w io.WriteCloser
+
+	switch compr {
+	case "gzip":
+		w = gzip.NewWriter(&buf)
+	case "xz":
+		xw, err := xz.NewWriter(&buf)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		w = xw
+	case "zstd":
+		zw, err := zstd.NewWriter(&buf)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		w = zw
+	default:
+		return data
+	}
+
+	if _, err := w.Write(data); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	return buf.Bytes()
+}
+
+func writeTestFile(t *testing.T, name string, data []byte) string {
+	name = filepath.Join(t.TempDir(), name)
+
+	if err := ioutil.WriteFile(name, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	return name
+}
+
+func TestOpenCompression(t *testing.T) {
+	lz4Data, _ := base64.StdEncoding.DecodeString(lz4TestFrame)
+
+	tests := []struct {
+		Compression string
+		Data        []byte
+	}{
+		{"", []byte(readerTestData)},
+		{"gzip", compressTestData(t, "gzip", []byte(readerTestData))},
+		{"xz", compressTestData(t, "xz", []byte(readerTestData))},
+		{"zstd", compressTestData(t, "zstd", []byte(readerTestData))},
+		{"lz4", lz4Data},
+	}
 
-	r := bytes.NewBufferString(s)
-	ur := &UniversalReader{r}
+	for _, test := range tests {
+		// The name does not reveal the compression.
+		r, err := Open(writeTestFile(t, "person.csv", test.Data), "", EncodingUTF8)
 
-	buf := make([]byte, 20)
-	n, err := ur.Read(buf)
+		if err != nil {
+			t.Errorf("%s: %s", test.Compression, err)
+			continue
+		}
+
+		b, err := ioutil.ReadAll(r)
+		r.Close()
+
+		if err != nil {
+			t.Errorf("%s: %s", test.Compression, err)
+		}
+
+		if r.Compression != test.Compression {
+			t.Errorf("expected %s compression, got %s", test.Compression, r.Compression)
+		}
+
+		if !bytes.HasPrefix(b, []byte("person_id,birth_date\n1,")) {
+			t.Errorf("%s: unexpected data %q", test.Compression, b)
+		}
+	}
+}
+
+func TestLZ4Checksum(t *testing.T) {
+	data, _ := base64.StdEncoding.DecodeString(lz4TestFrame)
+
+	// Corrupt the content checksum at the end of the frame.
+	data[len(data)-1] ^= 0xff
+
+	r, err := Open(writeTestFile(t, "person.csv.lz4", data), "", EncodingUTF8)
 
 	if err != nil {
-		t.Fatalf("problem reading: %s", err)
+		t.Fatal(err)
+	}
+
+	defer r.Close()
+
+	if _, err = ioutil.ReadAll(r); err == nil {
+		t.Error("expected checksum error")
+	}
+}
+
+func TestReadInputs(t *testing.T) {
+	var zbuf bytes.Buffer
+
+	zw := zip.NewWriter(&zbuf)
+
+	for _, name := range []string{"site/person.csv", "site/visit_occurrence.csv.gz"} {
+		data := []byte(readerTestData)
+
+		if strings.HasSuffix(name, ".gz") {
+			data = compressTestData(t, "gzip", data)
+		}
+
+		w, _ := zw.Create(name)
+		w.Write(data)
 	}
 
-	if cap(buf) != 20 {
-		t.Fatalf("expected 20 cap, got %d", cap(buf))
+	zw.Close()
+
+	var tbuf bytes.Buffer
+
+	tw := tar.NewWriter(&tbuf)
+
+	for _, name := range []string{"person.csv", "death.csv"} {
+		tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(readerTestData)), Typeflag: tar.TypeReg})
+		tw.Write([]byte(readerTestData))
 	}
 
-	if len(s)-3 != n {
-		t.Errorf("expected %d bytes, got %d", len(s)-3, n)
+	tw.Close()
+
+	tests := []struct {
+		Name    string
+		Data    []byte
+		Entries []string
+	}{
+		{"person.csv", []byte(readerTestData), []string{""}},
+		{"extract.zip", zbuf.Bytes(), []string{"site/person.csv", "site/visit_occurrence.csv.gz"}},
+		{"extract.tar.gz", compressTestData(t, "gzip", tbuf.Bytes()), []string{"person.csv", "death.csv"}},
 	}
 
-	exp := "hello world!\n"
+	for _, test := range tests {
+		var entries []string
+
+		err := ReadInputs(writeTestFile(t, test.Name, test.Data), "", EncodingUTF8, func(r *Reader, err error) error {
+			if err != nil {
+				return err
+			}
+
+			b, err := ioutil.ReadAll(r)
+
+			if err != nil {
+				return err
+			}
+
+			if string(b) != readerTestData {
+				t.Errorf("%s: unexpected data %q", r.Entry, b)
+			}
+
+			entries = append(entries, r.Entry)
+
+			return nil
+		})
+
+		if err != nil {
+			t.Errorf("%s: %s", test.Name, err)
+		}
+
+		if fmt.Sprint(entries) != fmt.Sprint(test.Entries) {
+			t.Errorf("%s: expected entries %v, got %v", test.Name, test.Entries, entries)
+		}
+	}
 
-	if string(buf[:n]) != exp {
-		t.Errorf("expected '%v', got '%v'", exp, string(buf[:n]))
+	if _, err := Open(writeTestFile(t, "extract.zip", zbuf.Bytes()), "", EncodingUTF8); err == nil {
+		t.Error("expected error opening archive")
 	}
 }
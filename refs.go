@@ -0,0 +1,462 @@
+package validator
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/chop-dbhi/data-models-service/client"
+)
+
+// DefaultReferenceSamples is the default number of orphan values kept as
+// samples for each foreign key.
+const DefaultReferenceSamples = 5
+
+// ModelReferences returns the foreign keys of the model. They are read from
+// the schema of the model or, without one, from the references of the
+// fields.
+func ModelReferences(model *client.Model) []*client.ForeignKey {
+	if model.Schema != nil && len(model.Schema.ForeignKeys) > 0 {
+		return model.Schema.ForeignKeys
+	}
+
+	var fks []*client.ForeignKey
+
+	for _, t := range model.Tables.List() {
+		for _, f := range t.Fields.List() {
+			if f.References == nil || f.References.Field.Table == nil {
+				continue
+			}
+
+			fks = append(fks, &client.ForeignKey{
+				Name:        f.References.Name,
+				SourceTable: t.Name,
+				SourceField: f.Name,
+				TargetTable: f.References.Field.Table.Name,
+				TargetField: f.References.Field.Name,
+			})
+		}
+	}
+
+	return fks
+}
+
+// refValue is a distinct value of a field.
+type refValue struct {
+	first keyLocation
+	count int
+}
+
+// valueSet holds the distinct values of a field and the number of times
+// each occurs. Values are held in memory up to the limit of the checker,
+// after which all values are written to partition files.
+type valueSet struct {
+	seen    map[string]*refValue
+	spilled *spillFiles
+
+	// True if the field was in an input.
+	read bool
+}
+
+func newValueSet() *valueSet {
+	return &valueSet{
+		seen: make(map[string]*refValue),
+	}
+}
+
+// each calls the function with the distinct values of the partition, or
+// of the set if it is held in memory.
+func (s *valueSet) each(j int, fn func(value string, v *refValue)) error {
+	if s.spilled == nil {
+		for value, v := range s.seen {
+			fn(value, v)
+		}
+
+		return nil
+	}
+
+	seen := make(map[string]*refValue)
+
+	err := s.spilled.read(j, func(value string, loc keyLocation, count int) {
+		if v, ok := seen[value]; ok {
+			v.count += count
+		} else {
+			seen[value] = &refValue{first: loc, count: count}
+		}
+	})
+
+	if err != nil {
+		return err
+	}
+
+	for value, v := range seen {
+		fn(value, v)
+	}
+
+	return nil
+}
+
+// ReferenceResult is the result of checking a foreign key.
+type ReferenceResult struct {
+	ForeignKey *client.ForeignKey
+
+	// Checked is false if the values of the referencing or the referenced
+	// field were not read, such as when the referenced table is not in the
+	// inputs. The reason says why.
+	Checked bool
+	Reason  string
+
+	// Orphans is the number of values that do not reference an existing
+	// value and Values the number of distinct orphan values.
+	Orphans int
+	Values  int
+
+	// Samples are the first orphan values in the order they were read.
+	Samples []*ValidationError
+}
+
+func (r *ReferenceResult) String() string {
+	fk := r.ForeignKey
+	return fmt.Sprintf("%s.%s -> %s.%s", fk.SourceTable, fk.SourceField, fk.TargetTable, fk.TargetField)
+}
+
+// ReferenceChecker checks the foreign keys of a model across the inputs of
+// a delivery. The values of the referencing and the referenced fields are
+// collected while the inputs are validated and compared once all inputs
+// are read. Null values do not reference anything and are not checked.
+type ReferenceChecker struct {
+	ForeignKeys []*client.ForeignKey
+
+	// MaxMemory is the approximate number of bytes of values held in memory
+	// before they are spilled to files in Dir. Defaults to DefaultKeyMemory.
+	MaxMemory int
+
+	// Dir is the directory values are spilled to. Defaults to the temporary
+	// directory of the system.
+	Dir string
+
+	// Samples is the number of orphan values kept for each foreign key.
+	// Defaults to DefaultReferenceSamples.
+	Samples int
+
+	// Values of the referenced fields by table and field and of the
+	// referencing field of each foreign key.
+	parents  map[string]*valueSet
+	children []*valueSet
+
+	// Tables that were read.
+	tables map[string]bool
+
+	size  int
+	seq   uint64
+	files inputFiles
+	dir   string
+}
+
+// NewReferenceChecker returns a reference checker for the foreign keys.
+func NewReferenceChecker(fks []*client.ForeignKey) *ReferenceChecker {
+	r := &ReferenceChecker{
+		ForeignKeys: fks,
+		parents:     make(map[string]*valueSet),
+		children:    make([]*valueSet, len(fks)),
+		tables:      make(map[string]bool),
+	}
+
+	for i, fk := range fks {
+		r.children[i] = newValueSet()
+
+		key := refKey(fk.TargetTable, fk.TargetField)
+
+		if _, ok := r.parents[key]; !ok {
+			r.parents[key] = newValueSet()
+		}
+	}
+
+	return r
+}
+
+func refKey(table, field string) string {
+	return strings.ToLower(table + "." + field)
+}
+
+// Spilled returns true if values have been spilled to disk.
+func (r *ReferenceChecker) Spilled() bool {
+	return r.dir != ""
+}
+
+// refColumn is a column of an input whose values are collected.
+type refColumn struct {
+	set *valueSet
+	col int
+}
+
+// columns returns the columns of an input of the table whose values are
+// collected. The fields are the fields the columns are mapped to.
+func (r *ReferenceChecker) columns(table string, fields map[int]*client.Field) []refColumn {
+	r.tables[strings.ToLower(table)] = true
+
+	column := func(name string) (int, bool) {
+		for i, f := range fields {
+			if strings.EqualFold(f.Name, name) {
+				return i, true
+			}
+		}
+
+		return 0, false
+	}
+
+	var cols []refColumn
+
+	added := make(map[*valueSet]bool)
+
+	add := func(s *valueSet, field string) {
+		if added[s] {
+			return
+		}
+
+		if i, ok := column(field); ok {
+			s.read = true
+			added[s] = true
+			cols = append(cols, refColumn{set: s, col: i})
+		}
+	}
+
+	for i, fk := range r.ForeignKeys {
+		if strings.EqualFold(fk.SourceTable, table) {
+			add(r.children[i], fk.SourceField)
+		}
+
+		if strings.EqualFold(fk.TargetTable, table) {
+			add(r.parents[refKey(fk.TargetTable, fk.TargetField)], fk.TargetField)
+		}
+	}
+
+	return cols
+}
+
+// add adds the value read at the line of the file to the set.
+func (r *ReferenceChecker) add(s *valueSet, value, file string, line int) error {
+	r.seq++
+
+	loc := keyLocation{
+		seq:  r.seq,
+		file: r.files.index(file),
+		line: line,
+	}
+
+	if s.spilled != nil {
+		return s.spilled.write(value, loc, 1)
+	}
+
+	if v, ok := s.seen[value]; ok {
+		v.count++
+		return nil
+	}
+
+	// The value is copied since it may share the memory of the record.
+	s.seen[strings.Clone(value)] = &refValue{first: loc, count: 1}
+	r.size += len(value) + spillOverhead
+
+	max := r.MaxMemory
+
+	if max <= 0 {
+		max = DefaultKeyMemory
+	}
+
+	if r.size > max {
+		return r.spill()
+	}
+
+	return nil
+}
+
+// sets returns the value sets with their names.
+func (r *ReferenceChecker) sets() map[string]*valueSet {
+	sets := make(map[string]*valueSet, len(r.parents)+len(r.children))
+
+	for key, s := range r.parents {
+		sets["p-"+key] = s
+	}
+
+	for i, s := range r.children {
+		sets[fmt.Sprintf("c-%d", i)] = s
+	}
+
+	return sets
+}
+
+// spill writes the values held in memory to partition files.
+func (r *ReferenceChecker) spill() error {
+	if r.dir == "" {
+		dir, err := os.MkdirTemp(r.Dir, "refs")
+
+		if err != nil {
+			return err
+		}
+
+		r.dir = dir
+	}
+
+	for name, s := range r.sets() {
+		if s.spilled == nil {
+			var err error
+
+			if s.spilled, err = newSpillFiles(r.dir, name); err != nil {
+				return err
+			}
+		}
+
+		for value, v := range s.seen {
+			if err := s.spilled.write(value, v.first, v.count); err != nil {
+				return err
+			}
+		}
+
+		s.seen = make(map[string]*refValue)
+	}
+
+	r.size = 0
+
+	return nil
+}
+
+// check compares the values of the referencing field to the values of the
+// referenced field.
+func (r *ReferenceChecker) check(res *ReferenceResult, parent, child *valueSet) error {
+	type orphan struct {
+		value string
+		v     *refValue
+	}
+
+	n := r.Samples
+
+	if n <= 0 {
+		n = DefaultReferenceSamples
+	}
+
+	var samples []orphan
+
+	partitions := 1
+
+	if r.dir != "" {
+		partitions = spillPartitions
+	}
+
+	for j := 0; j < partitions; j++ {
+		parents := parent.seen
+
+		if parent.spilled != nil {
+			parents = make(map[string]*refValue)
+
+			err := parent.each(j, func(value string, v *refValue) {
+				parents[value] = v
+			})
+
+			if err != nil {
+				return err
+			}
+		}
+
+		err := child.each(j, func(value string, v *refValue) {
+			if _, ok := parents[value]; ok {
+				return
+			}
+
+			res.Orphans += v.count
+			res.Values++
+
+			// Keep the first orphans read.
+			if len(samples) == n && samples[n-1].v.first.seq < v.first.seq {
+				return
+			}
+
+			i := sort.Search(len(samples), func(i int) bool {
+				return samples[i].v.first.seq > v.first.seq
+			})
+
+			samples = append(samples, orphan{})
+			copy(samples[i+1:], samples[i:])
+			samples[i] = orphan{value, v}
+
+			if len(samples) > n {
+				samples = samples[:n]
+			}
+		})
+
+		if err != nil {
+			return err
+		}
+	}
+
+	fk := res.ForeignKey
+
+	for _, o := range samples {
+		var file string
+
+		if len(r.files) > 1 {
+			file = r.files[o.v.first.file]
+		}
+
+		res.Samples = append(res.Samples, &ValidationError{
+			Err:   ErrOrphanReference,
+			File:  file,
+			Line:  o.v.first.line,
+			Field: fk.SourceField,
+			Value: o.value,
+			Context: Context{
+				"references":  fmt.Sprintf("%s.%s", fk.TargetTable, fk.TargetField),
+				"occurrences": o.v.count,
+			},
+		})
+	}
+
+	return nil
+}
+
+// Close checks the foreign keys whose referencing table was read and
+// removes the spilled files. The file of the samples is set if values of
+// more than one file were read.
+func (r *ReferenceChecker) Close() ([]*ReferenceResult, error) {
+	if r.dir != "" {
+		defer os.RemoveAll(r.dir)
+
+		defer func() {
+			for _, s := range r.sets() {
+				s.spilled.close()
+			}
+		}()
+	}
+
+	var results []*ReferenceResult
+
+	for i, fk := range r.ForeignKeys {
+		if !r.tables[strings.ToLower(fk.SourceTable)] {
+			continue
+		}
+
+		res := &ReferenceResult{ForeignKey: fk}
+
+		child := r.children[i]
+		parent := r.parents[refKey(fk.TargetTable, fk.TargetField)]
+
+		switch {
+		case !child.read:
+			res.Reason = fmt.Sprintf("field '%s' is not in the input", fk.SourceField)
+		case !r.tables[strings.ToLower(fk.TargetTable)]:
+			res.Reason = fmt.Sprintf("table '%s' was not read", fk.TargetTable)
+		case !parent.read:
+			res.Reason = fmt.Sprintf("field '%s' of table '%s' is not in the input", fk.TargetField, fk.TargetTable)
+		default:
+			res.Checked = true
+
+			if err := r.check(res, parent, child); err != nil {
+				return nil, err
+			}
+		}
+
+		results = append(results, res)
+	}
+
+	return results, nil
+}
@@ -0,0 +1,123 @@
+package validator
+
+import (
+	"bytes"
+	"os"
+	"testing"
+)
+
+func TestModelReferences(t *testing.T) {
+	model, err := (&DirProvider{Dir: writeModelDir(t)}).ModelRevision("pedsnet", "2.0.0")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fks := ModelReferences(model)
+
+	if len(fks) != 1 || fks[0].SourceTable != "visit_occurrence" || fks[0].TargetField != "person_id" {
+		t.Fatalf("wrong foreign keys %v", fks)
+	}
+
+	// The references of the fields are used without a schema.
+	model.Schema = nil
+
+	if fks = ModelReferences(model); len(fks) != 1 || fks[0].Name != "fk_visit_person" || fks[0].TargetTable != "person" {
+		t.Errorf("wrong foreign keys from fields %v", fks)
+	}
+}
+
+func TestReferenceChecker(t *testing.T) {
+	model, err := (&DirProvider{Dir: writeModelDir(t)}).ModelRevision("pedsnet", "2.0.0")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	inputs := []struct {
+		Table string
+		File  string
+		Data  string
+	}{
+		{"visit_occurrence", "visit_1.csv", "visit_occurrence_id,person_id\n1,1\n2,3\n3,\n"},
+		{"person", "person.csv", "person_id,birth_date\n1,2000-01-01\n2,2000-01-01\n"},
+		{"visit_occurrence", "visit_2.csv", "visit_occurrence_id,person_id\n4,2\n5,3\n6,4\n"},
+	}
+
+	run := func(r *ReferenceChecker, table, file, data string) {
+		v := New(bytes.NewBufferString(data), model.Tables.Get(table), nil)
+		v.Refs = r
+		v.File = file
+
+		if err := v.Init(); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := v.Run(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, max := range []int{0, 1} {
+		r := NewReferenceChecker(ModelReferences(model))
+		r.MaxMemory = max
+		r.Dir = t.TempDir()
+		r.Samples = 1
+
+		for _, in := range inputs {
+			run(r, in.Table, in.File, in.Data)
+		}
+
+		if r.Spilled() != (max == 1) {
+			t.Errorf("[%d] expected spilled %v", max, max == 1)
+		}
+
+		results, err := r.Close()
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(results) != 1 {
+			t.Fatalf("[%d] expected 1 result, got %v", max, results)
+		}
+
+		res := results[0]
+
+		if !res.Checked || res.Orphans != 3 || res.Values != 2 || len(res.Samples) != 1 {
+			t.Fatalf("[%d] wrong result %+v", max, res)
+		}
+
+		s := res.Samples[0]
+
+		if s.Err != ErrOrphanReference || s.File != "visit_1.csv" || s.Line != 3 || s.Value != "3" || s.Context["occurrences"] != 2 {
+			t.Errorf("[%d] wrong sample %s", max, s)
+		}
+
+		if entries, _ := os.ReadDir(r.Dir); len(entries) != 0 {
+			t.Errorf("[%d] expected spilled values to be removed", max)
+		}
+	}
+
+	// The referenced table was not read.
+	r := NewReferenceChecker(ModelReferences(model))
+	run(r, "visit_occurrence", "visit.csv", inputs[0].Data)
+
+	results, err := r.Close()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(results) != 1 || results[0].Checked || results[0].Reason != "table 'person' was not read" {
+		t.Errorf("expected unchecked reference, got %+v", results[0])
+	}
+
+	// Nothing is checked if the referencing table was not read.
+	r = NewReferenceChecker(ModelReferences(model))
+	run(r, "person", "person.csv", inputs[1].Data)
+
+	if results, _ = r.Close(); len(results) != 0 {
+		t.Errorf("expected no results, got %v", results)
+	}
+}
@@ -0,0 +1,217 @@
+package validator
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/chop-dbhi/data-models-service/client"
+)
+
+// PlanHook is called with the plan of a table after the validators are
+// bound and before the input is validated. It may add, replace or remove
+// validators in Plan.FieldValidators.
+type PlanHook func(table string, fields *client.Fields, plan *Plan)
+
+// registration binds a validator to the fields of a table matching a
+// pattern. An empty table matches all tables and a nil pattern all fields.
+type registration struct {
+	table     string
+	pattern   *regexp.Regexp
+	validator *Validator
+	context   Context
+}
+
+func (r *registration) matches(table string, f *client.Field) bool {
+	if r.table != "" && !strings.EqualFold(r.table, table) {
+		return false
+	}
+
+	return r.pattern == nil || r.pattern.MatchString(f.Name)
+}
+
+// Registry holds validators bound to fields in addition to the validators
+// for the field types. It is safe for concurrent use.
+type Registry struct {
+	mu     sync.RWMutex
+	types  map[string][]*registration
+	fields []*registration
+	rows   map[string][]*RowValidator
+	hooks  []PlanHook
+}
+
+// NewRegistry returns an empty registry.
+func NewRegistry() *Registry {
+	return &Registry{
+		types: make(map[string][]*registration),
+		rows:  make(map[string][]*RowValidator),
+	}
+}
+
+// DefaultRegistry is used by table validators without a registry.
+var DefaultRegistry = NewRegistry()
+
+// RegisterType binds the validator to all fields of the type. Type aliases
+// are resolved, so registering for a new type also makes it known in strict
+// mode.
+func (r *Registry) RegisterType(typ string, v *Validator, cxt Context) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	typ = CanonicalType(typ)
+	r.types[typ] = append(r.types[typ], &registration{validator: v, context: cxt})
+}
+
+// RegisterField binds the validator to the fields of all tables whose names
+// match the pattern. The pattern is a regular expression matched
+// case-insensitively against the whole field name.
+func (r *Registry) RegisterField(pattern string, v *Validator, cxt Context) error {
+	return r.RegisterTable("", pattern, v, cxt)
+}
+
+// RegisterTable binds the validator to the fields of the table whose names
+// match the pattern. An empty pattern matches all fields of the table.
+func (r *Registry) RegisterTable(table, pattern string, v *Validator, cxt Context) error {
+	reg := &registration{
+		table:     table,
+		validator: v,
+		context:   cxt,
+	}
+
+	if pattern != "" {
+		re, err := regexp.Compile("(?i)^(?:" + pattern + ")$")
+
+		if err != nil {
+			return err
+		}
+
+		reg.pattern = re
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.fields = append(r.fields, reg)
+
+	return nil
+}
+
+// RegisterRow adds a row validator for the table.
+func (r *Registry) RegisterRow(table string, v *RowValidator) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	table = strings.ToLower(table)
+	r.rows[table] = append(r.rows[table], v)
+}
+
+// RowValidators returns the row validators of the table.
+func (r *Registry) RowValidators(table string) []*RowValidator {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return append([]*RowValidator{}, r.rows[strings.ToLower(table)]...)
+}
+
+// AddPlanHook adds a hook that is called with the plan of each table.
+func (r *Registry) AddPlanHook(h PlanHook) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.hooks = append(r.hooks, h)
+}
+
+// HasType returns true if validators are registered for the type.
+func (r *Registry) HasType(typ string) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return len(r.types[CanonicalType(typ)]) > 0
+}
+
+// Bind returns the validators for the field of the table. These are the
+// validators for the field type followed by the registered validators.
+func (r *Registry) Bind(table string, f *client.Field) []*BoundValidator {
+	vs := BindFieldValidators(f)
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, reg := range r.types[CanonicalType(f.Type)] {
+		vs = append(vs, Bind(reg.validator, reg.context))
+	}
+
+	for _, reg := range r.fields {
+		if reg.matches(table, f) {
+			vs = append(vs, Bind(reg.validator, reg.context))
+		}
+	}
+
+	return vs
+}
+
+// runHooks calls the plan hooks in the order they were added.
+func (r *Registry) runHooks(table string, fields *client.Fields, plan *Plan) {
+	r.mu.RLock()
+	hooks := append([]PlanHook{}, r.hooks...)
+	r.mu.RUnlock()
+
+	for _, h := range hooks {
+		h(table, fields, plan)
+	}
+}
+
+// RegisterType binds the validator to all fields of the type in the
+// default registry.
+func RegisterType(typ string, v *Validator, cxt Context) {
+	DefaultRegistry.RegisterType(typ, v, cxt)
+}
+
+// RegisterField binds the validator to the fields matching the pattern in
+// the default registry.
+func RegisterField(pattern string, v *Validator, cxt Context) error {
+	return DefaultRegistry.RegisterField(pattern, v, cxt)
+}
+
+// RegisterTable binds the validator to the fields of the table matching the
+// pattern in the default registry.
+func RegisterTable(table, pattern string, v *Validator, cxt Context) error {
+	return DefaultRegistry.RegisterTable(table, pattern, v, cxt)
+}
+
+// RegisterRow adds a row validator for the table to the default registry.
+func RegisterRow(table string, v *RowValidator) {
+	DefaultRegistry.RegisterRow(table, v)
+}
+
+// AddPlanHook adds a plan hook to the default registry.
+func AddPlanHook(h PlanHook) {
+	DefaultRegistry.AddPlanHook(h)
+}
+
+var errorsMu sync.Mutex
+
+// RegisterError adds an error of a custom validator to Errors. Codes of
+// custom errors should be 1000 or greater to not conflict with the
+// validator's own codes.
+func RegisterError(e *Error) error {
+	errorsMu.Lock()
+	defer errorsMu.Unlock()
+
+	if x, ok := Errors[e.Code]; ok && x != e {
+		return fmt.Errorf("error code %d is already registered: %s", e.Code, x.Description)
+	}
+
+	Errors[e.Code] = e
+
+	return nil
+}
+
+// registeredError returns the error registered with the code, if any.
+func registeredError(code int) *Error {
+	errorsMu.Lock()
+	defer errorsMu.Unlock()
+
+	return Errors[code]
+}
@@ -0,0 +1,123 @@
+package validator
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	dms "github.com/chop-dbhi/data-models-service/client"
+)
+
+var errTestPrefix = &Error{
+	Code:        1000,
+	Description: "Value does not have the site prefix",
+}
+
+var testPrefixValidator = &Validator{
+	Name: "SitePrefix",
+
+	RequiresValue: true,
+
+	Validate: func(s string, cxt Context) *ValidationError {
+		if !strings.HasPrefix(s, cxt["prefix"].(string)) {
+			return &ValidationError{
+				Err: errTestPrefix,
+			}
+		}
+
+		return nil
+	},
+}
+
+func TestRegistryBind(t *testing.T) {
+	r := NewRegistry()
+	r.RegisterType("geometry", testPrefixValidator, Context{"prefix": "POINT"})
+
+	if err := r.RegisterField(".*_source_value", testPrefixValidator, Context{"prefix": "S"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := r.RegisterTable("person", "", testPrefixValidator, Context{"prefix": "P"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := r.RegisterField("(", testPrefixValidator, nil); err == nil {
+		t.Error("expected error for invalid pattern")
+	}
+
+	tests := []struct {
+		Table string
+		Field *dms.Field
+		Added int
+	}{
+		{"site", &dms.Field{Name: "shape", Type: "geometry"}, 1},
+		{"site", &dms.Field{Name: "site_source_value", Type: "string"}, 1},
+		{"site", &dms.Field{Name: "site_source_value_2", Type: "string"}, 0},
+		{"person", &dms.Field{Name: "gender_source_value", Type: "string"}, 2},
+		{"PERSON", &dms.Field{Name: "person_id", Type: "integer"}, 1},
+	}
+
+	for _, test := range tests {
+		added := len(r.Bind(test.Table, test.Field)) - len(BindFieldValidators(test.Field))
+
+		if added != test.Added {
+			t.Errorf("%s.%s: expected %d registered validators, got %d", test.Table, test.Field.Name, test.Added, added)
+		}
+	}
+
+	if !r.HasType("geometry") || r.HasType("point") {
+		t.Error("wrong registered types")
+	}
+}
+
+func TestRegistryTableValidator(t *testing.T) {
+	r := NewRegistry()
+
+	if err := r.RegisterTable("person", "person_id", testPrefixValidator, Context{"prefix": "1"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Replace the date validator of birth_date.
+	r.AddPlanHook(func(table string, fields *dms.Fields, plan *Plan) {
+		plan.FieldValidators["birth_date"] = []*BoundValidator{
+			Bind(DateValidator, Context{"layouts": []string{"01/02/2006"}}),
+		}
+	})
+
+	input := "person_id,birth_date\n1,01/02/2000\n2,2000-01-02\n"
+
+	v := New(bytes.NewBufferString(input), testTable(t, "person"), nil)
+	v.Registry = r
+
+	if err := v.Init(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := v.Run(); err != nil {
+		t.Fatal(err)
+	}
+
+	if errs := v.Result().FieldErrors("person_id")[errTestPrefix]; len(errs) != 1 || errs[0].Line != 3 {
+		t.Errorf("expected prefix error on line 3, got %v", errs)
+	}
+
+	if errs := v.Result().FieldErrors("birth_date")[ErrTypeMismatchDate]; len(errs) != 1 || errs[0].Line != 3 {
+		t.Errorf("expected date error on line 3, got %v", errs)
+	}
+}
+
+func TestRegisterError(t *testing.T) {
+	defer delete(Errors, errTestPrefix.Code)
+
+	if err := RegisterError(errTestPrefix); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := RegisterError(errTestPrefix); err != nil {
+		t.Errorf("unexpected error registering the same error %s", err)
+	}
+
+	if err := RegisterError(&Error{Code: ErrBadHeader.Code}); err == nil {
+		t.Error("expected error for a conflicting code")
+	}
+}
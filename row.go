@@ -0,0 +1,198 @@
+package validator
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/chop-dbhi/data-models-service/client"
+)
+
+// Row holds the non-null values of the record being validated by field.
+// The values are set before any validator of the record is run, so
+// validators bound with the row in their context can refer to them.
+type Row struct {
+	values map[string]string
+
+	table   string
+	fields  *client.Fields
+	formats *DateFormats
+
+	// Boolean literals of the table validator. The defaults are used if
+	// empty.
+	trueValues  []string
+	falseValues []string
+}
+
+// NewRow returns an empty row.
+func NewRow() *Row {
+	return &Row{
+		values: make(map[string]string),
+	}
+}
+
+// Value returns the value of the field. False is returned if the value is
+// null or the field is not in the input.
+func (r *Row) Value(field string) (string, bool) {
+	v, ok := r.values[strings.ToLower(field)]
+	return v, ok
+}
+
+// Set sets the value of the field.
+func (r *Row) Set(field, value string) {
+	r.values[strings.ToLower(field)] = value
+}
+
+// Reset removes all values.
+func (r *Row) Reset() {
+	for k := range r.values {
+		delete(r.values, k)
+	}
+}
+
+// Typed returns the value of the field parsed according to its type:
+// int64 for integers, float64 for numbers and decimals, time.Time for
+// dates, datetimes and times, bool for booleans and string otherwise.
+// False is returned if the value is null, not in the input or cannot be
+// parsed.
+func (r *Row) Typed(field string) (interface{}, bool) {
+	v, ok := r.Value(field)
+
+	if !ok {
+		return nil, false
+	}
+
+	var f *client.Field
+
+	if r.fields != nil {
+		f = r.fields.Get(field)
+	}
+
+	if f == nil {
+		return v, true
+	}
+
+	switch typ := CanonicalType(f.Type); typ {
+	case "integer", "biginteger", "smallinteger", "tinyinteger":
+		if i, err := strconv.ParseInt(v, 10, 64); err == nil {
+			return i, true
+		}
+	case "number", "decimal":
+		if x, err := strconv.ParseFloat(v, 64); err == nil {
+			return x, true
+		}
+	case "date", "datetime", "time":
+		layouts := r.formats.Layouts(r.table, f)
+
+		if len(layouts) == 0 {
+			switch typ {
+			case "date":
+				layouts = dateLayouts
+			case "datetime":
+				layouts = DefaultDatetimeLayouts
+			default:
+				layouts = DefaultTimeLayouts
+			}
+		}
+
+		for _, l := range layouts {
+			if t, err := time.Parse(l, v); err == nil {
+				return t, true
+			}
+		}
+	case "boolean":
+		trues, falses := r.trueValues, r.falseValues
+
+		if len(trues) == 0 {
+			trues = DefaultTrueValues
+		}
+
+		if len(falses) == 0 {
+			falses = DefaultFalseValues
+		}
+
+		for _, l := range trues {
+			if strings.EqualFold(v, l) {
+				return true, true
+			}
+		}
+
+		for _, l := range falses {
+			if strings.EqualFold(v, l) {
+				return false, true
+			}
+		}
+	default:
+		return v, true
+	}
+
+	return nil, false
+}
+
+// compareTyped compares two typed values of the same type. False is
+// returned if the values cannot be compared.
+func compareTyped(a, b interface{}) (int, bool) {
+	switch x := a.(type) {
+	case int64:
+		switch y := b.(type) {
+		case int64:
+			return compareInts(x, y), true
+		case float64:
+			return compareFloats(float64(x), y), true
+		}
+	case float64:
+		switch y := b.(type) {
+		case float64:
+			return compareFloats(x, y), true
+		case int64:
+			return compareFloats(x, float64(y)), true
+		}
+	case time.Time:
+		if y, ok := b.(time.Time); ok {
+			return x.Compare(y), true
+		}
+	case string:
+		if y, ok := b.(string); ok {
+			return strings.Compare(x, y), true
+		}
+	}
+
+	return 0, false
+}
+
+func compareInts(a, b int64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+
+	return 0
+}
+
+func compareFloats(a, b float64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+
+	return 0
+}
+
+// RowValidator validates a record as a whole, such as a constraint between
+// several fields. The fields are the ones the validator refers to.
+type RowValidator struct {
+	Name   string
+	Fields []string
+
+	// Validate returns an error if the row is not valid. The fields and
+	// value of the error are set by the table validator.
+	Validate func(row *Row) *ValidationError
+}
+
+func (v *RowValidator) String() string {
+	return v.Name
+}
@@ -0,0 +1,168 @@
+package validator
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+	"time"
+
+	dms "github.com/chop-dbhi/data-models-service/client"
+)
+
+func testVisitTable() *dms.Table {
+	fields := &dms.Fields{}
+	fields.Add(&dms.Field{Name: "visit_id", Type: "integer"})
+	fields.Add(&dms.Field{Name: "visit_start_date", Type: "date"})
+	fields.Add(&dms.Field{Name: "visit_end_date", Type: "datetime"})
+	fields.Add(&dms.Field{Name: "value_as_number", Type: "number"})
+	fields.Add(&dms.Field{Name: "value_as_string", Type: "string"})
+
+	return &dms.Table{Name: "visit", Fields: fields}
+}
+
+func TestRowTyped(t *testing.T) {
+	row := NewRow()
+	row.fields = testVisitTable().Fields
+
+	row.Set("visit_id", "10")
+	row.Set("visit_start_date", "2000-01-02")
+	row.Set("visit_end_date", "2000-01-01 10:00:00")
+	row.Set("value_as_number", "9.5")
+	row.Set("value_as_string", "abc")
+
+	if v, ok := row.Typed("visit_id"); !ok || v != int64(10) {
+		t.Errorf("expected integer, got %v", v)
+	}
+
+	start, _ := row.Typed("visit_start_date")
+	end, _ := row.Typed("Visit_End_Date")
+
+	if _, ok := start.(time.Time); !ok {
+		t.Fatalf("expected time, got %v", start)
+	}
+
+	if c, ok := compareTyped(start, end); !ok || c != 1 {
+		t.Errorf("expected start after end, got %d", c)
+	}
+
+	id, _ := row.Typed("visit_id")
+	num, _ := row.Typed("value_as_number")
+
+	if c, ok := compareTyped(id, num); !ok || c != 1 {
+		t.Errorf("expected 10 > 9.5, got %d", c)
+	}
+
+	str, _ := row.Typed("value_as_string")
+
+	if _, ok := compareTyped(num, str); ok {
+		t.Error("expected number and string not to be comparable")
+	}
+
+	if _, ok := row.Typed("missing"); ok {
+		t.Error("expected missing value")
+	}
+
+	row.Set("visit_id", "x")
+
+	if _, ok := row.Typed("visit_id"); ok {
+		t.Error("expected unparsable value")
+	}
+}
+
+func TestTableValidatorRowValidators(t *testing.T) {
+	r := NewRegistry()
+
+	r.RegisterRow("visit", &RowValidator{
+		Name:   "Ordered dates",
+		Fields: []string{"visit_start_date", "visit_end_date"},
+		Validate: func(row *Row) *ValidationError {
+			start, _ := row.Typed("visit_start_date")
+			end, _ := row.Typed("visit_end_date")
+
+			if c, ok := compareTyped(start, end); ok && c > 0 {
+				return &ValidationError{Err: ErrTypeMismatchDate}
+			}
+
+			return nil
+		},
+	})
+
+	// Not run since the field is not in the input.
+	r.RegisterRow("visit", &RowValidator{
+		Name:   "Value",
+		Fields: []string{"value_as_number"},
+		Validate: func(row *Row) *ValidationError {
+			return &ValidationError{Err: ErrRequiredValue}
+		},
+	})
+
+	input := "visit_id,visit_start_date,visit_end_date\n1,2000-01-01,2000-01-02 00:00:00\n2,2000-01-03,2000-01-02 00:00:00\n3,2000-01-03,\n"
+
+	v := New(bytes.NewBufferString(input), testVisitTable(), nil)
+	v.Registry = r
+	v.Lenient = true
+
+	if err := v.Init(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := v.Run(); err != nil {
+		t.Fatal(err)
+	}
+
+	lerrs := v.Result().LineErrors()
+
+	if len(lerrs) != 1 || len(lerrs[ErrTypeMismatchDate]) != 1 {
+		t.Fatalf("expected 1 row error, got %v", lerrs)
+	}
+
+	verr := lerrs[ErrTypeMismatchDate][0]
+
+	if verr.Line != 3 || fmt.Sprint(verr.Fields) != "[visit_start_date visit_end_date]" {
+		t.Errorf("wrong error %s", verr)
+	}
+
+	if verr.Value != "visit_start_date=2000-01-03, visit_end_date=2000-01-02 00:00:00" {
+		t.Errorf("wrong value %s", verr.Value)
+	}
+}
+
+func TestRowTypedBooleanValues(t *testing.T) {
+	fields := &dms.Fields{}
+	fields.Add(&dms.Field{Name: "visit_id", Type: "integer"})
+	fields.Add(&dms.Field{Name: "verified", Type: "boolean"})
+
+	var values []interface{}
+
+	r := NewRegistry()
+
+	r.RegisterRow("visit", &RowValidator{
+		Name:   "Verified",
+		Fields: []string{"verified"},
+		Validate: func(row *Row) *ValidationError {
+			v, _ := row.Typed("verified")
+			values = append(values, v)
+			return nil
+		},
+	})
+
+	input := "visit_id,verified\n1,Oui\n2,non\n3,true\n"
+
+	v := New(bytes.NewBufferString(input), &dms.Table{Name: "visit", Fields: fields}, nil)
+	v.Registry = r
+	v.TrueValues = []string{"oui"}
+	v.FalseValues = []string{"non"}
+
+	if err := v.Init(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := v.Run(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The default literals are not accepted with custom ones.
+	if fmt.Sprint(values) != "[true false <nil>]" {
+		t.Errorf("expected configured literals, got %v", values)
+	}
+}
@@ -0,0 +1,761 @@
+package validator
+
+import (
+	"fmt"
+	"io"
+	"math/big"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/chop-dbhi/data-models-service/client"
+	"gopkg.in/yaml.v3"
+)
+
+// Codes of rules without a code are assigned from this code on.
+const firstRuleCode = 1000
+
+// Condition holds if the field has one of the values or, without values,
+// if the field is not null.
+type Condition struct {
+	Field  string   `yaml:"field"`
+	Values []string `yaml:"values"`
+}
+
+// holds returns true if the condition holds for the row.
+func (c *Condition) holds(row *Row) bool {
+	v, ok := row.Value(c.Field)
+
+	if !ok {
+		return false
+	}
+
+	if len(c.Values) == 0 {
+		return true
+	}
+
+	for _, x := range c.Values {
+		if v == x {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Rule constrains the values of a field beyond the schema. Each of the
+// constraints that are set must be satisfied. Violations are reported with
+// the code and message of the rule.
+type Rule struct {
+	Table string `yaml:"table"`
+	Field string `yaml:"field"`
+
+	// Pattern is a regular expression values must match.
+	Pattern string `yaml:"pattern"`
+
+	// Min and Max are the inclusive bounds of numeric values.
+	Min string `yaml:"min"`
+	Max string `yaml:"max"`
+
+	// Values are the allowed values.
+	Values []string `yaml:"values"`
+
+	// MinDate and MaxDate are the inclusive bounds of date and datetime
+	// values formatted as 2006-01-02.
+	MinDate string `yaml:"min_date"`
+	MaxDate string `yaml:"max_date"`
+
+	// RequiredIf requires a value if the condition holds.
+	RequiredIf *Condition `yaml:"required_if"`
+
+	Code    int    `yaml:"code"`
+	Message string `yaml:"message"`
+
+	err      *Error
+	pattern  *regexp.Regexp
+	min, max *big.Rat
+	minDate  time.Time
+	maxDate  time.Time
+}
+
+// RowRule constrains the values of several fields of a record, either by
+// comparing two fields or by requiring at least one of the fields to have
+// a value. Violations are reported with the code and message of the rule
+// and name all the fields involved.
+type RowRule struct {
+	Table string `yaml:"table"`
+
+	// Left and Right are compared by the operator, one of <, <=, =, !=, >=
+	// or >. Typed values are compared, so dates compare chronologically
+	// and numbers numerically. Rows where either value is null are valid.
+	Left  string `yaml:"left"`
+	Op    string `yaml:"op"`
+	Right string `yaml:"right"`
+
+	// RequireAny requires at least one of the fields to have a value.
+	RequireAny []string `yaml:"require_any"`
+
+	Code    int    `yaml:"code"`
+	Message string `yaml:"message"`
+
+	err *Error
+}
+
+// RuleSet is a set of rules for a model. The version is optional.
+type RuleSet struct {
+	Model    string     `yaml:"model"`
+	Version  string     `yaml:"version"`
+	Rules    []*Rule    `yaml:"rules"`
+	RowRules []*RowRule `yaml:"row_rules"`
+}
+
+// ReadRuleSet reads a rule file in YAML or JSON and compiles the rules.
+func ReadRuleSet(r io.Reader) (*RuleSet, error) {
+	var rs RuleSet
+
+	dec := yaml.NewDecoder(r)
+	dec.KnownFields(true)
+
+	if err := dec.Decode(&rs); err != nil && err != io.EOF {
+		return nil, err
+	}
+
+	if err := rs.compile(); err != nil {
+		return nil, err
+	}
+
+	return &rs, nil
+}
+
+// RuleFileNames are the names of a rule file kept with the definition files
+// of a model revision, in order of precedence.
+var RuleFileNames = []string{"rules.yaml", "rules.yml", "rules.json"}
+
+// FindRuleFile returns the path of the rule file in the directory of a model
+// revision, such as a revision read from a local checkout of the data models
+// repository. An empty string is returned if there is none.
+func FindRuleFile(dir string) string {
+	if dir == "" {
+		return ""
+	}
+
+	for _, name := range RuleFileNames {
+		path := filepath.Join(dir, name)
+
+		if info, err := os.Stat(path); err == nil && !info.IsDir() {
+			return path
+		}
+	}
+
+	return ""
+}
+
+// OpenRuleSet reads a rule file by name.
+func OpenRuleSet(name string) (*RuleSet, error) {
+	f, err := os.Open(name)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer f.Close()
+
+	rs, err := ReadRuleSet(f)
+
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", name, err)
+	}
+
+	return rs, nil
+}
+
+// compile parses the constraints of the rules and assigns the codes of
+// rules without one. Assigned codes are not used by another rule or by an
+// error already registered, such as that of a custom validator.
+func (rs *RuleSet) compile() error {
+	codes := make(map[int]bool)
+
+	for _, r := range rs.Rules {
+		codes[r.Code] = true
+	}
+
+	for _, r := range rs.RowRules {
+		codes[r.Code] = true
+	}
+
+	next := firstRuleCode
+
+	newError := func(code int, msg string) *Error {
+		if code == 0 {
+			for codes[next] || registeredError(next) != nil {
+				next++
+			}
+
+			code = next
+			codes[code] = true
+		}
+
+		return &Error{
+			Code:        code,
+			Description: msg,
+		}
+	}
+
+	for i, r := range rs.Rules {
+		if err := r.compile(); err != nil {
+			return fmt.Errorf("rule %d (%s.%s): %s", i+1, r.Table, r.Field, err)
+		}
+
+		msg := r.Message
+
+		if msg == "" {
+			msg = r.describe()
+		}
+
+		r.err = newError(r.Code, msg)
+	}
+
+	for i, r := range rs.RowRules {
+		if err := r.compile(); err != nil {
+			return fmt.Errorf("row rule %d (%s): %s", i+1, r.Table, err)
+		}
+
+		msg := r.Message
+
+		if msg == "" {
+			msg = r.describe()
+		}
+
+		r.err = newError(r.Code, msg)
+	}
+
+	return nil
+}
+
+// Operators of row rules and the results of comparisons they accept.
+var rowRuleOps = map[string][]int{
+	"<":  {-1},
+	"<=": {-1, 0},
+	"=":  {0},
+	"==": {0},
+	"!=": {-1, 1},
+	">=": {0, 1},
+	">":  {1},
+}
+
+func (r *RowRule) compile() error {
+	if r.Table == "" {
+		return fmt.Errorf("table is required")
+	}
+
+	compare := r.Left != "" || r.Op != "" || r.Right != ""
+
+	if compare == (len(r.RequireAny) > 0) {
+		return fmt.Errorf("either a comparison or require_any is required")
+	}
+
+	if compare {
+		if r.Left == "" || r.Right == "" {
+			return fmt.Errorf("left and right are required")
+		}
+
+		if _, ok := rowRuleOps[r.Op]; !ok {
+			return fmt.Errorf("unknown operator '%s'", r.Op)
+		}
+	} else if len(r.RequireAny) < 2 {
+		return fmt.Errorf("require_any requires at least two fields")
+	}
+
+	return nil
+}
+
+// describe returns the default message of the row rule.
+func (r *RowRule) describe() string {
+	if len(r.RequireAny) > 0 {
+		return fmt.Sprintf("One of %s must have a value", strings.Join(r.RequireAny, ", "))
+	}
+
+	return fmt.Sprintf("Values must satisfy %s %s %s", r.Left, r.Op, r.Right)
+}
+
+// fields returns the fields the rule refers to.
+func (r *RowRule) fields() []string {
+	if len(r.RequireAny) > 0 {
+		return r.RequireAny
+	}
+
+	return []string{r.Left, r.Right}
+}
+
+// validator returns the row validator of the rule for the fields of the
+// table.
+func (r *RowRule) validator(fields *client.Fields) *RowValidator {
+	names := r.fields()
+	resolved := make([]string, len(names))
+
+	for i, name := range names {
+		if f := fields.Get(name); f != nil {
+			resolved[i] = f.Name
+		} else {
+			resolved[i] = name
+		}
+	}
+
+	return &RowValidator{
+		Name:   fmt.Sprintf("Rule %d", r.err.Code),
+		Fields: resolved,
+
+		Validate: func(row *Row) *ValidationError {
+			if len(r.RequireAny) > 0 {
+				for _, name := range resolved {
+					if _, ok := row.Value(name); ok {
+						return nil
+					}
+				}
+
+				return &ValidationError{
+					Err: r.err,
+				}
+			}
+
+			a, ok := row.Typed(resolved[0])
+
+			if !ok {
+				return nil
+			}
+
+			b, ok := row.Typed(resolved[1])
+
+			if !ok {
+				return nil
+			}
+
+			c, ok := compareTyped(a, b)
+
+			if !ok {
+				return nil
+			}
+
+			for _, x := range rowRuleOps[r.Op] {
+				if c == x {
+					return nil
+				}
+			}
+
+			return &ValidationError{
+				Err: r.err,
+				Context: Context{
+					"op": r.Op,
+				},
+			}
+		},
+	}
+}
+
+func (r *Rule) compile() error {
+	var err error
+
+	if r.Table == "" || r.Field == "" {
+		return fmt.Errorf("table and field are required")
+	}
+
+	if r.Pattern != "" {
+		if r.pattern, err = regexp.Compile(r.Pattern); err != nil {
+			return err
+		}
+	}
+
+	for _, b := range []struct {
+		s string
+		r **big.Rat
+	}{{r.Min, &r.min}, {r.Max, &r.max}} {
+		if b.s == "" {
+			continue
+		}
+
+		x, ok := new(big.Rat).SetString(b.s)
+
+		if !ok {
+			return fmt.Errorf("bound '%s' is not a number", b.s)
+		}
+
+		*b.r = x
+	}
+
+	for _, b := range []struct {
+		s string
+		t *time.Time
+	}{{r.MinDate, &r.minDate}, {r.MaxDate, &r.maxDate}} {
+		if b.s == "" {
+			continue
+		}
+
+		if *b.t, err = time.Parse(DateLayout, b.s); err != nil {
+			return fmt.Errorf("date '%s' is not formatted as %s", b.s, DateLayout)
+		}
+	}
+
+	if r.RequiredIf != nil && r.RequiredIf.Field == "" {
+		return fmt.Errorf("required_if requires a field")
+	}
+
+	if r.pattern == nil && r.min == nil && r.max == nil && len(r.Values) == 0 &&
+		r.MinDate == "" && r.MaxDate == "" && r.RequiredIf == nil {
+		return fmt.Errorf("no constraint")
+	}
+
+	return nil
+}
+
+// describe returns the default message of the rule.
+func (r *Rule) describe() string {
+	var parts []string
+
+	if r.Pattern != "" {
+		parts = append(parts, fmt.Sprintf("match %s", r.Pattern))
+	}
+
+	if r.Min != "" {
+		parts = append(parts, fmt.Sprintf("be at least %s", r.Min))
+	}
+
+	if r.Max != "" {
+		parts = append(parts, fmt.Sprintf("be at most %s", r.Max))
+	}
+
+	if len(r.Values) > 0 {
+		parts = append(parts, fmt.Sprintf("be one of %s", strings.Join(r.Values, ", ")))
+	}
+
+	if r.MinDate != "" {
+		parts = append(parts, fmt.Sprintf("be on or after %s", r.MinDate))
+	}
+
+	if r.MaxDate != "" {
+		parts = append(parts, fmt.Sprintf("be on or before %s", r.MaxDate))
+	}
+
+	if r.RequiredIf != nil {
+		if len(r.RequiredIf.Values) == 0 {
+			parts = append(parts, fmt.Sprintf("be set if %s is set", r.RequiredIf.Field))
+		} else {
+			parts = append(parts, fmt.Sprintf("be set if %s is %s", r.RequiredIf.Field, strings.Join(r.RequiredIf.Values, " or ")))
+		}
+	}
+
+	return fmt.Sprintf("Value must %s", strings.Join(parts, " and "))
+}
+
+// Errors returns the errors of the rules.
+// The errors of the row rules follow the errors of the field rules.
+func (rs *RuleSet) Errors() []*Error {
+	var errs []*Error
+
+	for _, r := range rs.Rules {
+		errs = append(errs, r.err)
+	}
+
+	for _, r := range rs.RowRules {
+		errs = append(errs, r.err)
+	}
+
+	return errs
+}
+
+// validator returns the validator for the pattern and allowed values of the
+// rule.
+func (r *Rule) validator() *Validator {
+	return &Validator{
+		Name: fmt.Sprintf("Rule %d", r.err.Code),
+
+		Description: r.err.Description,
+
+		RequiresValue: true,
+
+		Validate: func(s string, cxt Context) *ValidationError {
+			if r.pattern != nil && !r.pattern.MatchString(s) {
+				return &ValidationError{
+					Err:     r.err,
+					Context: Context{"pattern": r.Pattern},
+				}
+			}
+
+			if len(r.Values) > 0 {
+				for _, v := range r.Values {
+					if s == v {
+						return nil
+					}
+				}
+
+				return &ValidationError{
+					Err:     r.err,
+					Context: Context{"values": r.Values},
+				}
+			}
+
+			return nil
+		},
+	}
+}
+
+// boundsValidator returns the validator for the numeric and date bounds of
+// the rule. Dates are parsed with the layouts in the context, or the
+// default layouts if there are none.
+func (r *Rule) boundsValidator() *Validator {
+	return &Validator{
+		Name: fmt.Sprintf("Rule %d", r.err.Code),
+
+		Description: r.err.Description,
+
+		RequiresValue: true,
+
+		// Bounds are compared to the parsed value.
+		DependsOnParse: true,
+
+		Validate: func(s string, cxt Context) *ValidationError {
+			if r.min != nil || r.max != nil {
+				// Values that are not numbers are reported by the type validators.
+				if x, ok := new(big.Rat).SetString(s); ok {
+					if r.min != nil && x.Cmp(r.min) < 0 || r.max != nil && x.Cmp(r.max) > 0 {
+						return &ValidationError{
+							Err:     r.err,
+							Context: Context{"min": r.Min, "max": r.Max},
+						}
+					}
+				}
+			}
+
+			if r.MinDate != "" || r.MaxDate != "" {
+				layouts, _ := cxt["layouts"].([]string)
+
+				if t, ok := parseDate(s, layouts); ok {
+					if r.MinDate != "" && t.Before(r.minDate) || r.MaxDate != "" && t.After(r.maxDate.Add(24*time.Hour-1)) {
+						return &ValidationError{
+							Err:     r.err,
+							Context: Context{"minDate": r.MinDate, "maxDate": r.MaxDate},
+						}
+					}
+				}
+			}
+
+			return nil
+		},
+	}
+}
+
+// requiredValidator returns the validator of the conditional requiredness
+// of the rule. The condition is evaluated on the row in the context.
+func (r *Rule) requiredValidator() *Validator {
+	return &Validator{
+		Name: fmt.Sprintf("Rule %d", r.err.Code),
+
+		Description: r.err.Description,
+
+		ChecksNull: true,
+
+		Validate: func(s string, cxt Context) *ValidationError {
+			row, _ := cxt["row"].(*Row)
+
+			if row == nil || !r.RequiredIf.holds(row) {
+				return nil
+			}
+
+			return &ValidationError{
+				Err: r.err,
+				Context: Context{
+					"field":  r.RequiredIf.Field,
+					"values": r.RequiredIf.Values,
+				},
+			}
+		},
+	}
+}
+
+// parseDate parses a date or datetime with the layouts, or the default
+// layouts if there are none. The time is in UTC if the value has no
+// timezone.
+func parseDate(s string, layouts []string) (time.Time, bool) {
+	if len(layouts) == 0 {
+		layouts = dateLayouts
+	}
+
+	for _, l := range layouts {
+		if t, err := time.Parse(l, s); err == nil {
+			return t, true
+		}
+	}
+
+	return time.Time{}, false
+}
+
+// PlanHook returns a plan hook that adds the validators of the rules to the
+// fields of the table and the row validators of the row rules. Validators
+// for conditional requiredness refer to the row of the plan.
+func (rs *RuleSet) PlanHook() PlanHook {
+	return func(table string, fields *client.Fields, plan *Plan) {
+		for _, r := range rs.RowRules {
+			if strings.EqualFold(r.Table, table) {
+				plan.RowValidators = append(plan.RowValidators, r.validator(fields))
+			}
+		}
+
+		for _, r := range rs.Rules {
+			if !strings.EqualFold(r.Table, table) {
+				continue
+			}
+
+			f := fields.Get(r.Field)
+
+			if f == nil {
+				continue
+			}
+
+			// The field is not in the input.
+			if _, ok := plan.FieldValidators[f.Name]; !ok {
+				continue
+			}
+
+			vs := plan.FieldValidators[f.Name]
+
+			if r.pattern != nil || len(r.Values) > 0 {
+				vs = append(vs, Bind(r.validator(), nil))
+			}
+
+			if r.min != nil || r.max != nil || r.MinDate != "" || r.MaxDate != "" {
+				vs = append(vs, Bind(r.boundsValidator(), Context{
+					"layouts": plan.DateFormats.Layouts(table, f),
+				}))
+			}
+
+			if r.RequiredIf != nil {
+				if plan.Row == nil {
+					plan.Row = NewRow()
+				}
+
+				vs = append(vs, Bind(r.requiredValidator(), Context{"row": plan.Row}))
+			}
+
+			plan.FieldValidators[f.Name] = vs
+		}
+	}
+}
+
+// Lint returns the problems of the rules with respect to the model such as
+// tables and fields that do not exist and constraints that do not apply to
+// the type of the field.
+func (rs *RuleSet) Lint(model *client.Model) []string {
+	var problems []string
+
+	if rs.Model != "" && !strings.EqualFold(rs.Model, model.Name) {
+		problems = append(problems, fmt.Sprintf("rules are for model '%s', not '%s'", rs.Model, model.Name))
+	}
+
+	if rs.Version != "" && rs.Version != model.Version {
+		problems = append(problems, fmt.Sprintf("rules are for version '%s', not '%s'", rs.Version, model.Version))
+	}
+
+	codes := make(map[int]string)
+
+	checkCode := func(at string, err *Error) {
+		code := err.Code
+
+		if code < firstRuleCode {
+			problems = append(problems, fmt.Sprintf("%s: code %d is reserved for the validator, use %d or greater", at, code, firstRuleCode))
+		} else if e := registeredError(code); e != nil && e != err {
+			problems = append(problems, fmt.Sprintf("%s: code %d is already registered: %s", at, code, e.Description))
+		} else if other, ok := codes[code]; ok {
+			problems = append(problems, fmt.Sprintf("%s: code %d is also used by %s", at, code, other))
+		} else {
+			codes[code] = strings.SplitN(at, " (", 2)[0]
+		}
+	}
+
+	for i, r := range rs.Rules {
+		at := fmt.Sprintf("rule %d (%s.%s)", i+1, r.Table, r.Field)
+
+		checkCode(at, r.err)
+
+		table := model.Tables.Get(r.Table)
+
+		if table == nil {
+			problems = append(problems, fmt.Sprintf("%s: table '%s' does not exist", at, r.Table))
+			continue
+		}
+
+		f := table.Fields.Get(r.Field)
+
+		if f == nil {
+			problems = append(problems, fmt.Sprintf("%s: field '%s' does not exist in table '%s'", at, r.Field, table.Name))
+			continue
+		}
+
+		typ := CanonicalType(f.Type)
+
+		if (r.Min != "" || r.Max != "") && !isNumericType(typ) {
+			problems = append(problems, fmt.Sprintf("%s: min and max require a numeric field, not %s", at, f.Type))
+		}
+
+		if (r.MinDate != "" || r.MaxDate != "") && typ != "date" && typ != "datetime" {
+			problems = append(problems, fmt.Sprintf("%s: min_date and max_date require a date or datetime field, not %s", at, f.Type))
+		}
+
+		if r.RequiredIf != nil && table.Fields.Get(r.RequiredIf.Field) == nil {
+			problems = append(problems, fmt.Sprintf("%s: required_if field '%s' does not exist in table '%s'", at, r.RequiredIf.Field, table.Name))
+		}
+	}
+
+	for i, r := range rs.RowRules {
+		at := fmt.Sprintf("row rule %d (%s)", i+1, r.Table)
+
+		checkCode(at, r.err)
+
+		table := model.Tables.Get(r.Table)
+
+		if table == nil {
+			problems = append(problems, fmt.Sprintf("%s: table '%s' does not exist", at, r.Table))
+			continue
+		}
+
+		var types []string
+
+		for _, name := range r.fields() {
+			if f := table.Fields.Get(name); f == nil {
+				problems = append(problems, fmt.Sprintf("%s: field '%s' does not exist in table '%s'", at, name, table.Name))
+			} else {
+				types = append(types, CanonicalType(f.Type))
+			}
+		}
+
+		if len(r.RequireAny) == 0 && len(types) == 2 && !comparableTypes(types[0], types[1]) {
+			problems = append(problems, fmt.Sprintf("%s: %s and %s cannot be compared", at, types[0], types[1]))
+		}
+	}
+
+	return problems
+}
+
+// comparableTypes returns true if the typed values of the canonical types
+// can be compared.
+func comparableTypes(a, b string) bool {
+	if isNumericType(a) && isNumericType(b) {
+		return true
+	}
+
+	if (a == "date" || a == "datetime") && (b == "date" || b == "datetime") {
+		return true
+	}
+
+	return a == b && (a == "time" || a == "string")
+}
+
+// isNumericType returns true if the canonical type holds numbers.
+func isNumericType(typ string) bool {
+	switch typ {
+	case "integer", "biginteger", "smallinteger", "tinyinteger", "number", "decimal":
+		return true
+	}
+
+	return false
+}
@@ -0,0 +1,416 @@
+package validator
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var testRules = `model: pedsnet
+version: 2.0.0
+rules:
+  - table: person
+    field: person_id
+    min: 1
+    max: 1000
+    code: 1001
+    message: Person identifier is out of range
+  - table: person
+    field: birth_date
+    min_date: 1900-01-01
+    max_date: 2016-12-31
+  - table: person
+    field: birth_date
+    required_if:
+      field: person_id
+      values: [7]
+  - table: visit_occurrence
+    field: person_id
+    values: [1, 2, 3]
+`
+
+func TestReadRuleSet(t *testing.T) {
+	rs, err := ReadRuleSet(strings.NewReader(testRules))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	errs := rs.Errors()
+
+	if len(errs) != 4 {
+		t.Fatalf("expected 4 rules, got %d", len(errs))
+	}
+
+	// Rules without a code are assigned the next free code.
+	codes := []int{1001, 1000, 1002, 1003}
+
+	for i, e := range errs {
+		if e.Code != codes[i] {
+			t.Errorf("rule %d: expected code %d, got %d", i+1, codes[i], e.Code)
+		}
+	}
+
+	if errs[0].Description != "Person identifier is out of range" {
+		t.Errorf("wrong message %s", errs[0].Description)
+	}
+
+	if errs[1].Description != "Value must be on or after 1900-01-01 and be on or before 2016-12-31" {
+		t.Errorf("wrong default message %s", errs[1].Description)
+	}
+
+	// JSON is read as well.
+	if _, err = ReadRuleSet(strings.NewReader(`{"rules": [{"table": "person", "field": "person_id", "pattern": "^[0-9]+$"}]}`)); err != nil {
+		t.Errorf("unexpected error %s", err)
+	}
+
+	for _, input := range []string{
+		"rules:\n  - table: person\n    field: person_id\n",
+		"rules:\n  - table: person\n    field: person_id\n    pattern: '('\n",
+		"rules:\n  - table: person\n    field: person_id\n    min: abc\n",
+		"rules:\n  - table: person\n    field: birth_date\n    min_date: 01/01/2000\n",
+		"rules:\n  - table: person\n    field: person_id\n    maximum: 1\n",
+		"rules:\n  - field: person_id\n    max: 1\n",
+	} {
+		if _, err := ReadRuleSet(strings.NewReader(input)); err == nil {
+			t.Errorf("expected error for %q", input)
+		}
+	}
+}
+
+func TestRuleSetPlanHook(t *testing.T) {
+	rs, err := ReadRuleSet(strings.NewReader(testRules))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := NewRegistry()
+	r.AddPlanHook(rs.PlanHook())
+
+	input := "person_id,birth_date\n1,2000-01-01\n1001,1899-12-31\n7,\n8,\n5,2016-12-31 10:00:00\n"
+
+	v := New(bytes.NewBufferString(input), testTable(t, "person"), nil)
+	v.Registry = r
+
+	if err = v.Init(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = v.Run(); err != nil {
+		t.Fatal(err)
+	}
+
+	errs := rs.Errors()
+
+	if e := v.Result().FieldErrors("person_id")[errs[0]]; len(e) != 1 || e[0].Line != 3 {
+		t.Errorf("expected range error on line 3, got %v", e)
+	}
+
+	if e := v.Result().FieldErrors("birth_date")[errs[1]]; len(e) != 1 || e[0].Line != 3 {
+		t.Errorf("expected date range error on line 3, got %v", e)
+	}
+
+	if e := v.Result().FieldErrors("birth_date")[errs[2]]; len(e) != 1 || e[0].Line != 4 {
+		t.Errorf("expected required error on line 4, got %v", e)
+	}
+
+	// Dates are compared using the layouts of the field.
+	input = "person_id,birth_date\n1,01/01/2000\n2,12/31/1899\n"
+
+	v = New(bytes.NewBufferString(input), testTable(t, "person"), nil)
+	v.Registry = r
+	v.DateFormats = &DateFormats{Date: []string{"01/02/2006"}}
+
+	if err = v.Init(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = v.Run(); err != nil {
+		t.Fatal(err)
+	}
+
+	if e := v.Result().FieldErrors("birth_date")[errs[1]]; len(e) != 1 || e[0].Line != 3 {
+		t.Errorf("expected date range error on line 3, got %v", e)
+	}
+}
+
+func TestRuleSetBoundsDependOnParse(t *testing.T) {
+	rs, err := ReadRuleSet(strings.NewReader("rules:\n  - table: person\n    field: person_id\n    pattern: '^[0-9]+$'\n    min: 1\n"))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := NewRegistry()
+	r.AddPlanHook(rs.PlanHook())
+
+	v := New(bytes.NewBufferString("person_id,birth_date\nabc,2000-01-01\n"), testTable(t, "person"), nil)
+	v.Registry = r
+	v.AllErrors = true
+
+	if err = v.Init(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = v.Run(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The pattern is checked although the value is not an integer.
+	errs := v.Result().FieldErrors("person_id")
+
+	if e := errs[rs.Errors()[0]]; len(e) != 1 || e[0].Context["pattern"] == nil {
+		t.Errorf("expected pattern error, got %v", e)
+	}
+
+	if len(errs[ErrTypeMismatchInt]) != 1 {
+		t.Errorf("expected type error, got %v", errs)
+	}
+}
+
+func TestRuleSetLint(t *testing.T) {
+	model, err := (&DirProvider{Dir: writeModelDir(t)}).ModelRevision("pedsnet", "2.0.0")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rs, err := ReadRuleSet(strings.NewReader(testRules))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if problems := rs.Lint(model); len(problems) != 0 {
+		t.Errorf("unexpected problems %v", problems)
+	}
+
+	input := `model: omop
+rules:
+  - table: observation
+    field: value
+    pattern: x
+  - table: person
+    field: gender
+    pattern: x
+  - table: person
+    field: birth_date
+    min: 0
+    code: 200
+  - table: person
+    field: person_id
+    min_date: 2000-01-01
+    required_if:
+      field: death_date
+`
+
+	if rs, err = ReadRuleSet(strings.NewReader(input)); err != nil {
+		t.Fatal(err)
+	}
+
+	problems := rs.Lint(model)
+
+	expected := []string{
+		"not 'pedsnet'",
+		"table 'observation' does not exist",
+		"field 'gender' does not exist",
+		"code 200 is reserved",
+		"min and max require a numeric field",
+		"min_date and max_date require a date",
+		"required_if field 'death_date' does not exist",
+	}
+
+	if len(problems) != len(expected) {
+		t.Fatalf("expected %d problems, got %v", len(expected), problems)
+	}
+
+	for i, p := range problems {
+		if !strings.Contains(p, expected[i]) {
+			t.Errorf("expected %q, got %q", expected[i], p)
+		}
+	}
+}
+
+func TestRuleSetRegisteredCodes(t *testing.T) {
+	// A custom validator registered the first rule code.
+	custom := &Error{Code: firstRuleCode, Description: "Value is not a medical record number"}
+
+	if err := RegisterError(custom); err != nil {
+		t.Fatal(err)
+	}
+
+	defer delete(Errors, custom.Code)
+
+	rs, err := ReadRuleSet(strings.NewReader("rules:\n  - table: person\n    field: person_id\n    min: 1\n  - table: person\n    field: birth_date\n    min_date: 2000-01-01\n    code: 1000\n"))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if code := rs.Errors()[0].Code; code != firstRuleCode+1 {
+		t.Errorf("expected code %d, got %d", firstRuleCode+1, code)
+	}
+
+	model, err := (&DirProvider{Dir: writeModelDir(t)}).ModelRevision("pedsnet", "2.0.0")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	problems := rs.Lint(model)
+
+	if len(problems) != 1 || !strings.Contains(problems[0], "code 1000 is already registered") {
+		t.Errorf("expected registered code problem, got %v", problems)
+	}
+}
+
+func TestFindRuleFile(t *testing.T) {
+	model, err := (&DirProvider{Dir: writeModelDir(t)}).ModelRevision("pedsnet", "2.0.0")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if name := FindRuleFile(model.Path); name != "" {
+		t.Errorf("expected no rule file, got %s", name)
+	}
+
+	name := filepath.Join(model.Path, "rules.yml")
+
+	if err = ioutil.WriteFile(name, []byte(testRules), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if found := FindRuleFile(model.Path); found != name {
+		t.Errorf("expected %s, got %s", name, found)
+	}
+
+	if _, err = OpenRuleSet(name); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestRuleSetRowRules(t *testing.T) {
+	input := `rules:
+  - table: visit
+    field: visit_id
+    min: 1
+row_rules:
+  - table: visit
+    left: visit_start_date
+    op: "<="
+    right: Visit_End_Date
+  - table: visit
+    require_any: [value_as_number, value_as_string]
+    code: 1000
+    message: A value is required
+`
+
+	rs, err := ReadRuleSet(strings.NewReader(input))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	errs := rs.Errors()
+
+	if len(errs) != 3 || errs[0].Code != 1001 || errs[1].Code != 1002 || errs[2].Code != 1000 {
+		t.Fatalf("wrong errors %v", errs)
+	}
+
+	if errs[1].Description != "Values must satisfy visit_start_date <= Visit_End_Date" {
+		t.Errorf("wrong default message %s", errs[1].Description)
+	}
+
+	r := NewRegistry()
+	r.AddPlanHook(rs.PlanHook())
+
+	data := `visit_id,visit_start_date,visit_end_date,value_as_number,value_as_string
+1,2000-01-01,2000-01-01 00:00:00,1,
+2,2000-01-02,2000-01-01 00:00:00,,a
+3,2000-01-02,,,
+`
+
+	v := New(bytes.NewBufferString(data), testVisitTable(), nil)
+	v.Registry = r
+
+	if err = v.Init(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = v.Run(); err != nil {
+		t.Fatal(err)
+	}
+
+	lerrs := v.Result().LineErrors()
+
+	if e := lerrs[errs[1]]; len(e) != 1 || e[0].Line != 3 || strings.Join(e[0].Fields, ",") != "visit_start_date,visit_end_date" {
+		t.Errorf("expected comparison error on line 3, got %v", e)
+	}
+
+	if e := lerrs[errs[2]]; len(e) != 1 || e[0].Line != 4 || len(e[0].Fields) != 2 {
+		t.Errorf("expected require_any error on line 4, got %v", e)
+	}
+
+	for _, input := range []string{
+		"row_rules:\n  - table: visit\n",
+		"row_rules:\n  - table: visit\n    left: a\n    op: '<>'\n    right: b\n",
+		"row_rules:\n  - table: visit\n    left: a\n    op: '<'\n",
+		"row_rules:\n  - table: visit\n    require_any: [a]\n",
+		"row_rules:\n  - table: visit\n    left: a\n    op: '<'\n    right: b\n    require_any: [a, b]\n",
+		"row_rules:\n  - left: a\n    op: '<'\n    right: b\n",
+	} {
+		if _, err := ReadRuleSet(strings.NewReader(input)); err == nil {
+			t.Errorf("expected error for %q", input)
+		}
+	}
+
+	model, err := (&DirProvider{Dir: writeModelDir(t)}).ModelRevision("pedsnet", "2.0.0")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	input = `rules:
+  - table: person
+    field: person_id
+    min: 1
+    code: 1001
+row_rules:
+  - table: person
+    left: person_id
+    op: "<"
+    right: birth_date
+    code: 1001
+  - table: person
+    require_any: [person_id, death_date]
+  - table: visit
+    require_any: [a, b]
+`
+
+	if rs, err = ReadRuleSet(strings.NewReader(input)); err != nil {
+		t.Fatal(err)
+	}
+
+	problems := rs.Lint(model)
+
+	expected := []string{
+		"row rule 1 (person): code 1001 is also used by rule 1",
+		"integer and date cannot be compared",
+		"field 'death_date' does not exist",
+		"table 'visit' does not exist",
+	}
+
+	if len(problems) != len(expected) {
+		t.Fatalf("expected %d problems, got %v", len(expected), problems)
+	}
+
+	for i, p := range problems {
+		if !strings.Contains(p, expected[i]) {
+			t.Errorf("expected %q, got %q", expected[i], p)
+		}
+	}
+}
@@ -0,0 +1,641 @@
+package validator
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/chop-dbhi/data-models-service/client"
+)
+
+// SchemaProvider provides the model revisions the validator checks inputs
+// against. The data models service client satisfies this interface.
+type SchemaProvider interface {
+	// ModelRevisions returns all revisions of a model.
+	ModelRevisions(name string) (*client.Models, error)
+
+	// ModelRevision returns a specific revision of a model.
+	ModelRevision(name, version string) (*client.Model, error)
+}
+
+// NewServiceProvider returns a provider backed by the data models service
+// at the URL. The service is pinged to ensure it is available.
+func NewServiceProvider(service string) (SchemaProvider, error) {
+	c, err := client.New(service)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if err = c.Ping(); err != nil {
+		return nil, err
+	}
+
+	return &ServiceProvider{c}, nil
+}
+
+// ServiceProvider provides model revisions from the data models service.
+// The service returns the schema of a revision separately, so it is added
+// to the revisions returned by ModelRevision. Responses with a status code
+// other than 2xx are returned as a ServiceError.
+type ServiceProvider struct {
+	*client.Client
+}
+
+// ServiceError is a response of the data models service with a status code
+// other than 2xx.
+type ServiceError struct {
+	URL        string
+	StatusCode int
+}
+
+func (e *ServiceError) Error() string {
+	return fmt.Sprintf("service %s responded with status code %d", e.URL, e.StatusCode)
+}
+
+// get returns the body of the response of the service to a GET request of
+// the path.
+func (p *ServiceProvider) get(elem ...string) ([]byte, error) {
+	u, err := url.Parse(p.URL)
+
+	if err != nil {
+		return nil, err
+	}
+
+	u.Path = "/" + path.Join(elem...)
+
+	req, err := http.NewRequest("GET", u.String(), nil)
+
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := (&http.Client{Timeout: p.Timeout}).Do(req)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, &ServiceError{URL: p.URL, StatusCode: resp.StatusCode}
+	}
+
+	return ioutil.ReadAll(resp.Body)
+}
+
+// ModelRevision implements the SchemaProvider interface. The fields of the
+// tables are kept in the order of the response.
+func (p *ServiceProvider) ModelRevision(name, version string) (*client.Model, error) {
+	b, err := p.get("models", name, version)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var m client.Model
+
+	if err = json.Unmarshal(b, &m); err != nil {
+		return nil, fmt.Errorf("error decoding model: %w", err)
+	}
+
+	// The tables and fields are sorted by name when decoded.
+	var order struct {
+		Tables []struct {
+			Name   string `json:"name"`
+			Fields []struct {
+				Name string `json:"name"`
+			} `json:"fields"`
+		} `json:"tables"`
+	}
+
+	if err = json.Unmarshal(b, &order); err != nil {
+		return nil, fmt.Errorf("error decoding model: %w", err)
+	}
+
+	for _, ot := range order.Tables {
+		t := m.Tables.Get(ot.Name)
+
+		if t == nil || t.Fields == nil {
+			continue
+		}
+
+		names := make([]string, len(ot.Fields))
+
+		for i, f := range ot.Fields {
+			names[i] = f.Name
+		}
+
+		setFieldOrder(t.Fields, names)
+	}
+
+	if m.Schema, err = p.schema(name, version); err != nil {
+		return nil, err
+	}
+
+	return &m, nil
+}
+
+// ModelRevisions implements the SchemaProvider interface.
+func (p *ServiceProvider) ModelRevisions(name string) (*client.Models, error) {
+	b, err := p.get("models", name)
+
+	if err != nil {
+		return nil, err
+	}
+
+	models := new(client.Models)
+
+	if err = json.Unmarshal(b, models); err != nil {
+		return nil, fmt.Errorf("error decoding model revisions: %w", err)
+	}
+
+	return models, nil
+}
+
+// schema returns the schema of the revision.
+func (p *ServiceProvider) schema(name, version string) (*client.Schema, error) {
+	b, err := p.get("schemata", name, version)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var aux struct {
+		Schema *client.Schema `json:"schema"`
+	}
+
+	if err = json.Unmarshal(b, &aux); err != nil {
+		return nil, fmt.Errorf("error decoding schema: %w", err)
+	}
+
+	if aux.Schema == nil {
+		return nil, fmt.Errorf("error decoding schema: no schema in the response")
+	}
+
+	return aux.Schema, nil
+}
+
+// fieldPosition is the attribute holding the position of a field in the
+// order its model declares the fields of the table. Fields are kept sorted
+// by name, so the declared order is recorded separately.
+const fieldPosition = "position"
+
+// setFieldOrder records the order of the named fields.
+func setFieldOrder(fields *client.Fields, names []string) {
+	for i, name := range names {
+		f := fields.Get(name)
+
+		if f == nil {
+			continue
+		}
+
+		if f.Attrs == nil {
+			f.Attrs = make(client.Attrs)
+		}
+
+		f.Attrs[fieldPosition] = strconv.Itoa(i + 1)
+	}
+}
+
+// FieldOrder returns the fields in the order the model declares them: the
+// order of the fields file of a local model or the order the service lists
+// them in. Fields without a declared position follow sorted by name.
+func FieldOrder(fields *client.Fields) []*client.Field {
+	l := append([]*client.Field(nil), fields.List()...)
+
+	position := func(f *client.Field) int {
+		if p, err := strconv.Atoi(f.Attrs[fieldPosition]); err == nil && p > 0 {
+			return p
+		}
+
+		return math.MaxInt32
+	}
+
+	sort.SliceStable(l, func(i, j int) bool {
+		return position(l[i]) < position(l[j])
+	})
+
+	return l
+}
+
+// Types of definition files found in a data models repository.
+const (
+	unknownFile = iota
+	fieldsFile
+	tablesFile
+	schemataFile
+	referencesFile
+	indexesFile
+	constraintsFile
+	modelsFile
+)
+
+// Minimum set of columns that identifies each type of definition file.
+var definitionFileFields = map[int][]string{
+	fieldsFile:      {"model", "version", "table", "field", "description"},
+	tablesFile:      {"model", "version", "table", "description"},
+	schemataFile:    {"model", "version", "table", "field", "type", "length", "precision", "scale", "default"},
+	constraintsFile: {"model", "version", "table", "field", "type", "name"},
+	indexesFile:     {"model", "version", "table", "field", "name", "order"},
+	referencesFile:  {"version", "table", "field", "ref_table", "ref_field", "name"},
+	modelsFile:      {"model", "version", "label", "description", "url"},
+}
+
+// The tables file columns are a subset of the other files so it must
+// be checked last.
+var definitionFileOrder = []int{
+	fieldsFile,
+	schemataFile,
+	indexesFile,
+	constraintsFile,
+	referencesFile,
+	tablesFile,
+	modelsFile,
+}
+
+func detectDefinitionFile(header []string) int {
+	cols := make(map[string]struct{}, len(header))
+
+	for _, c := range header {
+		cols[c] = struct{}{}
+	}
+
+	for _, ft := range definitionFileOrder {
+		match := true
+
+		for _, c := range definitionFileFields[ft] {
+			if _, ok := cols[c]; !ok {
+				match = false
+				break
+			}
+		}
+
+		if match {
+			return ft
+		}
+	}
+
+	return unknownFile
+}
+
+// readDefinitionFile reads a definitions file and returns the type of file
+// and records keyed by column name.
+func readDefinitionFile(name string) (int, []client.Attrs, error) {
+	f, err := os.Open(name)
+
+	if err != nil {
+		return unknownFile, nil, err
+	}
+
+	defer f.Close()
+
+	cr := csv.NewReader(f)
+	cr.LazyQuotes = true
+	cr.TrimLeadingSpace = true
+	cr.FieldsPerRecord = -1
+
+	head, err := cr.Read()
+
+	if err != nil {
+		if err == io.EOF {
+			return unknownFile, nil, nil
+		}
+
+		return unknownFile, nil, fmt.Errorf("%s: %s", name, err)
+	}
+
+	for i, c := range head {
+		head[i] = strings.ToLower(strings.TrimSpace(c))
+	}
+
+	ft := detectDefinitionFile(head)
+
+	if ft == unknownFile {
+		return ft, nil, nil
+	}
+
+	var records []client.Attrs
+
+	for {
+		row, err := cr.Read()
+
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			return ft, nil, fmt.Errorf("%s: %s", name, err)
+		}
+
+		attrs := make(client.Attrs, len(head))
+
+		for i, c := range head {
+			if i < len(row) {
+				attrs[c] = strings.TrimSpace(row[i])
+			}
+		}
+
+		records = append(records, attrs)
+	}
+
+	return ft, records, nil
+}
+
+func isYes(s string) bool {
+	switch strings.ToLower(s) {
+	case "yes", "y", "1", "true":
+		return true
+	}
+
+	return false
+}
+
+// DirProvider provides model revisions from a local checkout of the
+// data models repository. Revisions are expected to be laid out as
+// <name>/<version>/ either directly in the directory or under models/.
+type DirProvider struct {
+	Dir string
+}
+
+// modelDir returns the directory containing the revisions of a model.
+func (p *DirProvider) modelDir(name string) (string, error) {
+	for _, dir := range []string{
+		filepath.Join(p.Dir, "models", name),
+		filepath.Join(p.Dir, name),
+	} {
+		if info, err := os.Stat(dir); err == nil && info.IsDir() {
+			return dir, nil
+		}
+	}
+
+	return "", fmt.Errorf("model '%s' not found in %s", name, p.Dir)
+}
+
+// ModelRevisions implements the SchemaProvider interface.
+func (p *DirProvider) ModelRevisions(name string) (*client.Models, error) {
+	dir, err := p.modelDir(name)
+
+	if err != nil {
+		return nil, err
+	}
+
+	infos, err := ioutil.ReadDir(dir)
+
+	if err != nil {
+		return nil, err
+	}
+
+	models := new(client.Models)
+
+	for _, info := range infos {
+		if !info.IsDir() {
+			continue
+		}
+
+		m := &client.Model{
+			Name:    name,
+			Version: info.Name(),
+			Path:    filepath.Join(dir, info.Name()),
+		}
+
+		if err = parseModelDir(m); err != nil {
+			return nil, err
+		}
+
+		// Directory without any table definitions.
+		if m.Tables.Len() == 0 {
+			continue
+		}
+
+		models.Add(m)
+	}
+
+	if models.Len() == 0 {
+		return nil, fmt.Errorf("no revisions of model '%s' found in %s", name, dir)
+	}
+
+	return models, nil
+}
+
+// ModelRevision implements the SchemaProvider interface.
+func (p *DirProvider) ModelRevision(name, version string) (*client.Model, error) {
+	revisions, err := p.ModelRevisions(name)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if m := revisions.Get(name, version); m != nil {
+		return m, nil
+	}
+
+	return nil, fmt.Errorf("version '%s' of model '%s' not found in %s", version, name, p.Dir)
+}
+
+// parseModelDir parses the definition files in the model's path and
+// links the tables, fields, and references.
+func parseModelDir(m *client.Model) error {
+	var (
+		tables  []client.Attrs
+		refs    []client.Attrs
+		fields  = make(map[string][]client.Attrs)
+		schemas = make(map[string]client.Attrs)
+	)
+
+	m.Schema = &client.Schema{
+		ForeignKeys:  make([]*client.ForeignKey, 0),
+		NotNullables: make([]*client.NotNullable, 0),
+	}
+
+	err := filepath.Walk(m.Path, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if info.IsDir() || filepath.Ext(path) != ".csv" {
+			return nil
+		}
+
+		ft, records, err := readDefinitionFile(path)
+
+		if err != nil {
+			return err
+		}
+
+		switch ft {
+		case modelsFile:
+			if len(records) > 0 {
+				m.Label = records[0]["label"]
+				m.Description = records[0]["description"]
+				m.URL = records[0]["url"]
+				m.Release = &client.Release{
+					Level:  records[0]["release_level"],
+					Serial: records[0]["release_serial"],
+				}
+			}
+
+		case tablesFile:
+			tables = append(tables, records...)
+
+		case fieldsFile:
+			for _, r := range records {
+				t := strings.ToLower(r["table"])
+				fields[t] = append(fields[t], r)
+			}
+
+		case schemataFile:
+			for _, r := range records {
+				schemas[strings.ToLower(r["table"]+"."+r["field"])] = r
+			}
+
+		case referencesFile:
+			for _, r := range records {
+				refs = append(refs, r)
+				m.Schema.AddForeignKey(r)
+			}
+
+		case constraintsFile:
+			for _, r := range records {
+				switch strings.ToLower(r["type"]) {
+				case "primary key":
+					m.Schema.AddPrimaryKey(r)
+				case "unique":
+					m.Schema.AddUnique(r)
+				case "not null":
+					m.Schema.AddNotNullable(r)
+				}
+			}
+
+		case indexesFile:
+			for _, r := range records {
+				m.Schema.AddIndex(r)
+			}
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return err
+	}
+
+	m.Tables = new(client.Tables)
+
+	for _, attrs := range tables {
+		t := &client.Table{
+			Name:        attrs["table"],
+			Label:       attrs["label"],
+			Description: attrs["description"],
+			Fields:      new(client.Fields),
+			Model:       m,
+			Attrs:       attrs,
+		}
+
+		var names []string
+
+		for _, fattrs := range fields[strings.ToLower(t.Name)] {
+			names = append(names, fattrs["field"])
+
+			f := &client.Field{
+				Name:        fattrs["field"],
+				Label:       fattrs["label"],
+				Description: fattrs["description"],
+				Required:    isYes(fattrs["required"]),
+				Table:       t,
+				Attrs:       fattrs,
+			}
+
+			if sattrs, ok := schemas[strings.ToLower(t.Name+"."+f.Name)]; ok {
+				if err = applyFieldSchema(f, sattrs); err != nil {
+					return fmt.Errorf("%s: %s", m.Path, err)
+				}
+			}
+
+			t.Fields.Add(f)
+		}
+
+		setFieldOrder(t.Fields, names)
+
+		m.Tables.Add(t)
+	}
+
+	// Fields with a not null constraint are required.
+	for _, nn := range m.Schema.NotNullables {
+		if t := m.Tables.Get(nn.Table); t != nil {
+			if f := t.Fields.Get(nn.Field); f != nil {
+				f.Required = true
+			}
+		}
+	}
+
+	// Link references between fields.
+	for _, attrs := range refs {
+		t := m.Tables.Get(attrs["table"])
+		rt := m.Tables.Get(attrs["ref_table"])
+
+		if t == nil || rt == nil {
+			continue
+		}
+
+		f := t.Fields.Get(attrs["field"])
+		rf := rt.Fields.Get(attrs["ref_field"])
+
+		if f == nil || rf == nil {
+			continue
+		}
+
+		f.References = &client.Reference{
+			Name:  attrs["name"],
+			Field: rf,
+			Attrs: attrs,
+		}
+
+		rf.InboundRefs = append(rf.InboundRefs, &client.Reference{
+			Name:  attrs["name"],
+			Field: f,
+		})
+	}
+
+	return nil
+}
+
+// applyFieldSchema sets the type information on the field.
+func applyFieldSchema(f *client.Field, attrs client.Attrs) error {
+	var err error
+
+	f.Type = attrs["type"]
+	f.Default = attrs["default"]
+
+	ints := map[string]*int{
+		"length":    &f.Length,
+		"precision": &f.Precision,
+		"scale":     &f.Scale,
+	}
+
+	for k, p := range ints {
+		if attrs[k] == "" {
+			continue
+		}
+
+		if *p, err = strconv.Atoi(attrs[k]); err != nil {
+			return fmt.Errorf("invalid %s '%s' for field %s", k, attrs[k], f.Name)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,191 @@
+package validator
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/chop-dbhi/data-models-service/client"
+)
+
+var modelFiles = map[string]string{
+	"tables.csv": `model,version,table,description
+pedsnet,2.0.0,person,"A person."
+pedsnet,2.0.0,visit_occurrence,"A visit."
+`,
+	"fields.csv": `model,version,table,field,description,required
+pedsnet,2.0.0,person,person_id,"Identifier.",Yes
+pedsnet,2.0.0,person,birth_date,"Date of birth.",No
+pedsnet,2.0.0,visit_occurrence,visit_occurrence_id,"Identifier.",No
+pedsnet,2.0.0,visit_occurrence,person_id,"Person.",Yes
+`,
+	"schema.csv": `model,version,table,field,type,length,precision,scale,default
+pedsnet,2.0.0,person,person_id,integer,,,,
+pedsnet,2.0.0,person,birth_date,date,,,,
+pedsnet,2.0.0,visit_occurrence,visit_occurrence_id,integer,,,,
+pedsnet,2.0.0,visit_occurrence,person_id,integer,,,,
+`,
+	"references.csv": `model,version,table,field,ref_table,ref_field,name
+pedsnet,2.0.0,visit_occurrence,person_id,person,person_id,fk_visit_person
+`,
+	"constraints.csv": `model,version,table,field,type,name
+pedsnet,2.0.0,person,person_id,primary key,person_pkey
+pedsnet,2.0.0,visit_occurrence,visit_occurrence_id,not null,
+`,
+}
+
+func writeModelDir(t *testing.T) string {
+	root := t.TempDir()
+	dir := filepath.Join(root, "models", "pedsnet", "2.0.0")
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, content := range modelFiles {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return root
+}
+
+func TestDirProvider(t *testing.T) {
+	p := &DirProvider{Dir: writeModelDir(t)}
+
+	revisions, err := p.ModelRevisions("pedsnet")
+
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	model := revisions.Latest()
+
+	if model.Version != "2.0.0" {
+		t.Fatalf("expected version 2.0.0, got %s", model.Version)
+	}
+
+	table := model.Tables.Get("person")
+
+	if table == nil {
+		t.Fatalf("expected person table")
+	}
+
+	if table.Fields.Len() != 2 {
+		t.Errorf("expected 2 fields, got %d", table.Fields.Len())
+	}
+
+	// Fields are in the order of the fields file rather than by name.
+	if names := fieldNames(FieldOrder(table.Fields)); names != "[person_id birth_date]" {
+		t.Errorf("expected declared field order, got %s", names)
+	}
+
+	f := table.Fields.Get("person_id")
+
+	if !f.Required || f.Type != "integer" {
+		t.Errorf("wrong field definition %+v", f)
+	}
+
+	// Not null constraints make fields required.
+	if f := model.Tables.Get("visit_occurrence").Fields.Get("visit_occurrence_id"); !f.Required {
+		t.Errorf("expected not null field to be required")
+	}
+
+	ref := model.Tables.Get("visit_occurrence").Fields.Get("person_id").References
+
+	if ref == nil || ref.Field != f {
+		t.Errorf("expected reference to person.person_id")
+	}
+
+	if _, err = p.ModelRevisions("omop"); err == nil {
+		t.Errorf("expected error for unknown model")
+	}
+}
+
+// testTable returns a table of the test model.
+func testTable(t *testing.T, name string) *client.Table {
+	model, err := (&DirProvider{Dir: writeModelDir(t)}).ModelRevision("pedsnet", "2.0.0")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return model.Tables.Get(name)
+}
+
+func fieldNames(fields []*client.Field) string {
+	names := make([]string, len(fields))
+
+	for i, f := range fields {
+		names[i] = f.Name
+	}
+
+	return fmt.Sprint(names)
+}
+
+// testService serves a revision of the pedsnet model.
+func testService(t *testing.T) *httptest.Server {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/models/pedsnet/2.0.0", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"name": "pedsnet", "version": "2.0.0", "tables": [
+			{"name": "person", "fields": [
+				{"name": "person_id", "type": "integer", "required": true},
+				{"name": "birth_date", "type": "date"}
+			]}
+		]}`)
+	})
+
+	mux.HandleFunc("/schemata/pedsnet/2.0.0", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"schema": {"indexes": [], "constraints": {"foreign_keys": [], "not_null": [], "primary_keys": [], "uniques": []}}}`)
+	})
+
+	s := httptest.NewServer(mux)
+	t.Cleanup(s.Close)
+
+	return s
+}
+
+func TestServiceProvider(t *testing.T) {
+	c, err := client.New(testService(t).URL)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := &ServiceProvider{c}
+
+	model, err := p.ModelRevision("pedsnet", "2.0.0")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if model.Schema == nil {
+		t.Error("expected schema")
+	}
+
+	// Fields are in the order of the response rather than by name.
+	cache := &SchemaCache{Dir: t.TempDir()}
+
+	if err = cache.Put("test", model); err != nil {
+		t.Fatal(err)
+	}
+
+	cached, err := cache.Get("test", "pedsnet", "2.0.0")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, m := range []*client.Model{model, cached} {
+		if names := fieldNames(FieldOrder(m.Tables.Get("person").Fields)); names != "[person_id birth_date]" {
+			t.Errorf("expected declared field order, got %s", names)
+		}
+	}
+}
@@ -0,0 +1,161 @@
+package validator
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// Number of files values are partitioned into when spilled to disk. The
+// values of one partition are held in memory when they are checked.
+const spillPartitions = 64
+
+// Approximate memory used by a value held in memory in addition to its
+// bytes.
+const spillOverhead = 64
+
+// spillFiles holds values spilled to disk partitioned by their hash, so
+// equal values are in the same partition. Each record is the location of
+// the value, the number of occurrences and the value.
+type spillFiles struct {
+	parts []*os.File
+	bufs  []*bufio.Writer
+}
+
+// newSpillFiles creates the partition files in the directory. The names of
+// the files start with the name.
+func newSpillFiles(dir, name string) (*spillFiles, error) {
+	s := &spillFiles{
+		parts: make([]*os.File, spillPartitions),
+		bufs:  make([]*bufio.Writer, spillPartitions),
+	}
+
+	for j := range s.parts {
+		f, err := os.Create(filepath.Join(dir, fmt.Sprintf("%s-%d", name, j)))
+
+		if err != nil {
+			s.close()
+			return nil, err
+		}
+
+		s.parts[j] = f
+		s.bufs[j] = bufio.NewWriter(f)
+	}
+
+	return s, nil
+}
+
+// write writes the value to its partition file.
+func (s *spillFiles) write(value string, loc keyLocation, count int) error {
+	h := fnv.New32a()
+	h.Write([]byte(value))
+
+	w := s.bufs[h.Sum32()%spillPartitions]
+
+	var b [5 * binary.MaxVarintLen64]byte
+
+	n := binary.PutUvarint(b[:], loc.seq)
+	n += binary.PutUvarint(b[n:], uint64(loc.file))
+	n += binary.PutUvarint(b[n:], uint64(loc.line))
+	n += binary.PutUvarint(b[n:], uint64(count))
+	n += binary.PutUvarint(b[n:], uint64(len(value)))
+
+	if _, err := w.Write(b[:n]); err != nil {
+		return err
+	}
+
+	_, err := w.WriteString(value)
+	return err
+}
+
+// read calls the function with the records of the partition in the order
+// they were written.
+func (s *spillFiles) read(j int, fn func(value string, loc keyLocation, count int)) error {
+	if err := s.bufs[j].Flush(); err != nil {
+		return err
+	}
+
+	f := s.parts[j]
+
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	r := bufio.NewReader(f)
+
+	for {
+		var vs [5]uint64
+
+		err := readUvarints(r, vs[:])
+
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+
+		b := make([]byte, vs[4])
+
+		if _, err = io.ReadFull(r, b); err != nil {
+			return err
+		}
+
+		loc := keyLocation{
+			seq:  vs[0],
+			file: int(vs[1]),
+			line: int(vs[2]),
+		}
+
+		fn(string(b), loc, int(vs[3]))
+	}
+
+	// Reading leaves the file at its end for further writes.
+	return nil
+}
+
+// close closes the partition files. The files are removed with the
+// directory they were created in.
+func (s *spillFiles) close() {
+	for _, f := range s.parts {
+		if f != nil {
+			f.Close()
+		}
+	}
+}
+
+// inputFiles are the names of the inputs values were read from. Values
+// refer to their input by its index.
+type inputFiles []string
+
+// index returns the index of the input. Values are read from one input
+// at a time, so only the last input is compared.
+func (f *inputFiles) index(name string) int {
+	if n := len(*f); n > 0 && (*f)[n-1] == name {
+		return n - 1
+	}
+
+	*f = append(*f, name)
+	return len(*f) - 1
+}
+
+// readUvarints reads the values of a record. EOF is only returned if the
+// reader is at the end before the first value.
+func readUvarints(r *bufio.Reader, vs []uint64) error {
+	var err error
+
+	for i := range vs {
+		if vs[i], err = binary.ReadUvarint(r); err != nil {
+			if err == io.EOF && i > 0 {
+				return io.ErrUnexpectedEOF
+			}
+
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,288 @@
+package validator
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/chop-dbhi/data-models-service/client"
+)
+
+// TableRule maps file names matching the pattern to a table. The table may
+// refer to submatches of the pattern such as $1.
+type TableRule struct {
+	Pattern *regexp.Regexp
+	Table   string
+}
+
+// NewTableRule compiles a rule. Patterns are matched case-insensitively
+// against the file name without the directory and extensions.
+func NewTableRule(pattern, table string) (*TableRule, error) {
+	re, err := regexp.Compile("(?i)" + pattern)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &TableRule{
+		Pattern: re,
+		Table:   table,
+	}, nil
+}
+
+// TableRules are the rules for mapping file names to tables in addition to
+// matching the file name against the table names.
+type TableRules struct {
+	// Prefixes and suffixes stripped from file names, such as a site name.
+	Prefixes []string
+	Suffixes []string
+
+	Rules []*TableRule
+}
+
+// AmbiguousTableError is returned if a file name matches several tables.
+type AmbiguousTableError struct {
+	Name   string
+	Tables []string
+}
+
+func (e *AmbiguousTableError) Error() string {
+	return fmt.Sprintf("'%s' matches several tables: %s", e.Name, strings.Join(e.Tables, ", "))
+}
+
+// candidates returns the names the file name may refer to in order of
+// precedence. Names of the same precedence are in the same set.
+func (r *TableRules) candidates(base string) [][]string {
+	var sets [][]string
+
+	if r == nil {
+		r = &TableRules{}
+	}
+
+	var rules []string
+
+	for _, rule := range r.Rules {
+		if m := rule.Pattern.FindStringSubmatchIndex(base); m != nil {
+			rules = append(rules, string(rule.Pattern.ExpandString(nil, rule.Table, base, m)))
+		}
+	}
+
+	sets = append(sets, rules, []string{base})
+
+	// Names with a prefix, a suffix or both stripped.
+	strip := func(name string) []string {
+		var names []string
+
+		for _, n := range append([]string{name}, stripAffixes(name, r.Prefixes, true)...) {
+			if n != name {
+				names = append(names, n)
+			}
+
+			names = append(names, stripAffixes(n, r.Suffixes, false)...)
+		}
+
+		return names
+	}
+
+	stripped := strip(base)
+
+	sets = append(sets, stripped)
+
+	// Split parts of the names. The part number may follow a suffix.
+	var parts []string
+
+	if loc := partSuffix.FindStringIndex(base); loc != nil {
+		parts = append(parts, base[:loc[0]])
+		parts = append(parts, strip(base[:loc[0]])...)
+	}
+
+	for _, name := range stripped {
+		if loc := partSuffix.FindStringIndex(name); loc != nil {
+			parts = append(parts, name[:loc[0]])
+		}
+	}
+
+	return append(sets, parts)
+}
+
+// stripAffixes returns the name with each of the prefixes or suffixes
+// removed that it has. Affixes are compared case-insensitively.
+func stripAffixes(name string, affixes []string, prefix bool) []string {
+	var names []string
+
+	lower := strings.ToLower(name)
+
+	for _, a := range affixes {
+		a = strings.ToLower(a)
+
+		if a == "" || len(a) >= len(name) {
+			continue
+		}
+
+		if prefix && strings.HasPrefix(lower, a) {
+			names = append(names, name[len(a):])
+		} else if !prefix && strings.HasSuffix(lower, a) {
+			names = append(names, name[:len(name)-len(a)])
+		}
+	}
+
+	return names
+}
+
+// Match returns the table the file name corresponds to. The rules take
+// precedence followed by the file name itself, the file name stripped of
+// the prefixes and suffixes and finally the name without the suffix of
+// split parts. If several tables match at the same level, an
+// *AmbiguousTableError is returned. If no table matches, nil is returned.
+func (r *TableRules) Match(tables *client.Tables, name string) (*client.Table, error) {
+	base := TableName(name)
+
+	for _, set := range r.candidates(base) {
+		var matched []*client.Table
+
+		seen := make(map[*client.Table]bool)
+
+		for _, c := range set {
+			if t := tables.Get(c); t != nil && !seen[t] {
+				seen[t] = true
+				matched = append(matched, t)
+			}
+		}
+
+		switch len(matched) {
+		case 0:
+			continue
+		case 1:
+			return matched[0], nil
+		}
+
+		names := make([]string, len(matched))
+
+		for i, t := range matched {
+			names[i] = t.Name
+		}
+
+		sort.Strings(names)
+
+		return nil, &AmbiguousTableError{
+			Name:   name,
+			Tables: names,
+		}
+	}
+
+	return nil, nil
+}
+
+// ReadTableRules reads a rules file. The file is a CSV file with the columns
+// type, pattern and table. The type is prefix or suffix for affixes to strip
+// from file names or regex for a rule mapping file names matching the pattern
+// to the table.
+func ReadTableRules(r io.Reader) (*TableRules, error) {
+	cr := csv.NewReader(r)
+	cr.TrimLeadingSpace = true
+	cr.FieldsPerRecord = -1
+
+	head, err := cr.Read()
+
+	rules := &TableRules{}
+
+	if err != nil {
+		if err == io.EOF {
+			return rules, nil
+		}
+
+		return nil, err
+	}
+
+	index := map[string]int{
+		"type":    -1,
+		"pattern": -1,
+		"table":   -1,
+	}
+
+	for i, c := range head {
+		c = strings.ToLower(strings.TrimSpace(c))
+
+		if _, ok := index[c]; ok {
+			index[c] = i
+		}
+	}
+
+	if index["type"] == -1 || index["pattern"] == -1 {
+		return nil, fmt.Errorf("rules require a type and pattern column")
+	}
+
+	get := func(row []string, c string) string {
+		if i := index[c]; i >= 0 && i < len(row) {
+			return strings.TrimSpace(row[i])
+		}
+
+		return ""
+	}
+
+	for line := 2; ; line++ {
+		row, err := cr.Read()
+
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		pattern := get(row, "pattern")
+
+		if pattern == "" {
+			continue
+		}
+
+		switch typ := strings.ToLower(get(row, "type")); typ {
+		case "prefix":
+			rules.Prefixes = append(rules.Prefixes, pattern)
+		case "suffix":
+			rules.Suffixes = append(rules.Suffixes, pattern)
+		case "regex":
+			table := get(row, "table")
+
+			if table == "" {
+				return nil, fmt.Errorf("line %d: regex rule requires a table", line)
+			}
+
+			rule, err := NewTableRule(pattern, table)
+
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %s", line, err)
+			}
+
+			rules.Rules = append(rules.Rules, rule)
+		default:
+			return nil, fmt.Errorf("line %d: unknown rule type '%s'", line, typ)
+		}
+	}
+
+	return rules, nil
+}
+
+// OpenTableRules reads a rules file by name.
+func OpenTableRules(name string) (*TableRules, error) {
+	f, err := os.Open(name)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer f.Close()
+
+	rules, err := ReadTableRules(f)
+
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", name, err)
+	}
+
+	return rules, nil
+}
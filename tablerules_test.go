@@ -0,0 +1,109 @@
+package validator
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+)
+
+func TestTableRulesMatch(t *testing.T) {
+	model, err := (&DirProvider{Dir: writeModelDir(t)}).ModelRevision("pedsnet", "2.0.0")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	site, _ := NewTableRule(`^SITE_(.*)_\d{8}$`, "$1")
+	visits, _ := NewTableRule(`^visits$`, "visit_occurrence")
+
+	rules := &TableRules{
+		Prefixes: []string{"pedsnet_"},
+		Suffixes: []string{"_extract"},
+		Rules:    []*TableRule{site, visits},
+	}
+
+	tests := []struct {
+		Rules *TableRules
+		Name  string
+		Table string
+	}{
+		{nil, "person.csv", "person"},
+		{nil, "data/PERSON.csv.gz", "person"},
+		{nil, "person_001.csv.gz", "person"},
+		{nil, "visit_occurrence-2.csv", "visit_occurrence"},
+		{nil, "person_part3.csv", "person"},
+		{nil, "observation.csv", ""},
+		{nil, "person_extra.csv", ""},
+		{nil, "PEDSNET_PERSON.csv", ""},
+		{rules, "PEDSNET_PERSON.csv", "person"},
+		{rules, "person_extract_002.csv", "person"},
+		{rules, "site_Visit_Occurrence_20160101.csv", "visit_occurrence"},
+		{rules, "visits.csv.gz", "visit_occurrence"},
+		{rules, "SITE_observation_20160101.csv", ""},
+	}
+
+	for _, test := range tests {
+		table, err := test.Rules.Match(model.Tables, test.Name)
+
+		if err != nil {
+			t.Errorf("%s: %s", test.Name, err)
+			continue
+		}
+
+		var name string
+
+		if table != nil {
+			name = table.Name
+		}
+
+		if name != test.Table {
+			t.Errorf("%s: expected %q, got %q", test.Name, test.Table, name)
+		}
+	}
+
+	// Stripping the prefix and the suffix leaves different tables.
+	rules = &TableRules{Prefixes: []string{"person_"}, Suffixes: []string{"_visit_occurrence"}}
+
+	if _, err = rules.Match(model.Tables, "person_visit_occurrence.csv"); err == nil {
+		t.Fatal("expected ambiguous match")
+	}
+
+	aerr, ok := err.(*AmbiguousTableError)
+
+	if !ok || fmt.Sprint(aerr.Tables) != "[person visit_occurrence]" {
+		t.Errorf("wrong error %v", err)
+	}
+}
+
+func TestReadTableRules(t *testing.T) {
+	input := `type,pattern,table
+prefix,PEDSNET_,
+suffix,_extract,
+regex,"^SITE_(.*)_\d{8}$",$1
+`
+
+	rules, err := ReadTableRules(bytes.NewBufferString(input))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(rules.Prefixes) != 1 || len(rules.Suffixes) != 1 || len(rules.Rules) != 1 {
+		t.Fatalf("wrong rules %+v", rules)
+	}
+
+	if rules.Rules[0].Table != "$1" || !rules.Rules[0].Pattern.MatchString("site_person_20160101") {
+		t.Errorf("wrong rule %+v", rules.Rules[0])
+	}
+
+	for _, input := range []string{
+		"type,pattern\nglob,*.csv\n",
+		"type,pattern,table\nregex,(,x\n",
+		"type,pattern,table\nregex,x,\n",
+		"pattern,table\nx,y\n",
+	} {
+		if _, err := ReadTableRules(bytes.NewBufferString(input)); err == nil {
+			t.Errorf("expected error for %q", input)
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package validator
+
+import "strings"
+
+// TypeAliases maps alternate names of field types to the types validators
+// are bound for. Types are compared case-insensitively.
+var TypeAliases = map[string]string{
+	"clob":              "string",
+	"text":              "string",
+	"varchar":           "string",
+	"char":              "string",
+	"character varying": "string",
+	"nvarchar":          "string",
+	"int":               "integer",
+	"int4":              "integer",
+	"bigint":            "biginteger",
+	"int8":              "biginteger",
+	"smallint":          "smallinteger",
+	"int2":              "smallinteger",
+	"tinyint":           "tinyinteger",
+	"float":             "number",
+	"double":            "number",
+	"real":              "number",
+	"numeric":           "decimal",
+	"timestamp":         "datetime",
+	"bool":              "boolean",
+	"guid":              "uuid",
+}
+
+// Types that validators are bound for.
+var knownTypes = map[string]bool{
+	"string":       true,
+	"integer":      true,
+	"biginteger":   true,
+	"smallinteger": true,
+	"tinyinteger":  true,
+	"number":       true,
+	"decimal":      true,
+	"date":         true,
+	"datetime":     true,
+	"time":         true,
+	"boolean":      true,
+	"uuid":         true,
+}
+
+// CanonicalType returns the type the alias refers to. Unknown types are
+// returned in lower case.
+func CanonicalType(typ string) string {
+	typ = strings.ToLower(strings.TrimSpace(typ))
+
+	if t, ok := TypeAliases[typ]; ok {
+		return t
+	}
+
+	return typ
+}
+
+// KnownType returns true if validators are bound for the type or its alias.
+func KnownType(typ string) bool {
+	return knownTypes[CanonicalType(typ)]
+}
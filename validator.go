@@ -1,26 +1,115 @@
 package validator
 
 import (
+	"fmt"
 	"io"
+	"log"
+	"sort"
 	"strings"
 
 	"github.com/chop-dbhi/data-models-service/client"
 )
 
+// Number of bytes of a record that exceeds the maximum size kept in the result.
+const recordSampleSize = 100
+
 // Plan is composed of the set of validators used to evaluate
 // the field values.
 type Plan struct {
 	FieldValidators map[string][]*BoundValidator
+
+	// RowValidators validate the record as a whole. They are only run if
+	// all of their fields are in the input.
+	RowValidators []*RowValidator
+
+	// Row is set if validators refer to other fields of the record. It
+	// holds the values of the record being validated.
+	Row *Row
+
+	// DateFormats are the layouts of the date, datetime and time fields of
+	// the table validator, for hooks that parse values.
+	DateFormats *DateFormats
 }
 
 type TableValidator struct {
 	Fields *client.Fields
 	Header []string
 
+	// Mapping maps columns in the header to fields before the header is
+	// matched against the fields.
+	Mapping HeaderMapping
+
+	// If true, the input does not have a header and the first line is data.
+	// The columns are given by Columns, the columns of the table in the
+	// mapping or the order of the fields, in that order of precedence.
+	NoHeader bool
+	Columns  []string
+
+	// MaxRecordSize is the maximum size of a record in bytes. Larger records
+	// are logged and skipped. Zero means records are unbounded.
+	MaxRecordSize int
+
+	// Quoting defines which values must be quoted. Defaults to the quoting
+	// policy of the dialect.
+	Quoting QuotingPolicy
+
+	// NullTokens are unquoted values that denote null in addition to the
+	// empty value, such as NULL, \N or NA.
+	NullTokens []string
+
+	// If true, a quoted empty value in a string field is an empty string
+	// rather than null and satisfies required fields.
+	EmptyStrings bool
+
+	// If true, a header that does not match the fields does not stop the
+	// validation. The header problems are logged as table errors, columns
+	// mapped to fields are validated and unknown columns are ignored.
+	Lenient bool
+
+	// If true, the columns in the header must be in the order of the fields.
+	EnforceOrder bool
+
+	// DateFormats are the layouts accepted for date, datetime and time
+	// fields and the timezone policy. The default layouts are used if nil.
+	DateFormats *DateFormats
+
+	// TrueValues and FalseValues are the literals accepted for boolean
+	// fields. The defaults are used if empty.
+	TrueValues  []string
+	FalseValues []string
+
+	// If true, fields of unknown types are logged as table errors. Otherwise
+	// they are only validated for their encoding and required values.
+	Strict bool
+
+	// Registry provides the validators registered for fields and the plan
+	// hooks. Defaults to DefaultRegistry.
+	Registry *Registry
+
+	// If true, every validator of a value is run and each failure is logged
+	// rather than only the first. Validators that depend on the parse of the
+	// value are still skipped if it fails.
+	AllErrors bool
+
+	// Keys checks the primary key and unique constraints of the table. It
+	// may be shared by the validators of the inputs of a table, such as
+	// split parts, and is closed by the caller once all are validated.
+	// Constraints with fields not in the input are not checked.
+	Keys *KeyChecker
+
+	// Refs collects the values of the foreign keys of the table and of the
+	// fields other tables refer to. It is shared by the validators of all
+	// inputs and closed by the caller once all are validated.
+	Refs *ReferenceChecker
+
+	// File names the input in the errors of checks across inputs.
+	File string
+
 	Plan   *Plan
 	result *Result
 
 	errs   int
+	table  string
 	length int
 	reader io.Reader
 	csv    *CSVReader
@@ -28,6 +117,35 @@ type TableValidator struct {
 	// Mapped field index to field.
 	fields map[int]*client.Field
 	record []string
+
+	// Column indexes of the fields of each key constraint. Nil if the
+	// constraint is not checked.
+	keyColumns [][]int
+	keyValues  []string
+
+	// Columns whose values are collected for the foreign keys.
+	refColumns []refColumn
+
+	nulls map[string]struct{}
+
+	// Suggested fields for unknown columns.
+	suggestions map[string][]string
+}
+
+// isNull returns true if the value of the field is null. Unquoted empty
+// values and null tokens are null. Quoted values are never null tokens, but
+// quoted empty values are only empty strings if enabled for string fields.
+func (t *TableValidator) isNull(f *client.Field, v string, quoted bool) bool {
+	if v == "" {
+		return !quoted || !t.EmptyStrings || !IsStringType(f.Type)
+	}
+
+	if quoted {
+		return false
+	}
+
+	_, ok := t.nulls[v]
+	return ok
 }
 
 func (t *TableValidator) validateRow(row []string) error {
@@ -35,9 +153,10 @@ func (t *TableValidator) validateRow(row []string) error {
 	// may be shifted relative to the header.
 	if len(row) != t.length {
 		t.result.LogError(&ValidationError{
-			Value: t.csv.Line(),
-			Line:  t.csv.LineNumber(),
-			Err:   ErrExtraColumns,
+			Value:  t.csv.Line(),
+			Line:   t.csv.LineNumber(),
+			Record: t.csv.RecordNumber(),
+			Err:    ErrExtraColumns,
 			Context: Context{
 				"expected": t.length,
 				"actual":   len(row),
@@ -47,34 +166,202 @@ func (t *TableValidator) validateRow(row []string) error {
 		return nil
 	}
 
+	quoted := t.csv.QuotedFields()
+
+	if r := t.Plan.Row; r != nil {
+		r.Reset()
+
+		for i, v := range row {
+			if f := t.fields[i]; f != nil && !t.isNull(f, v, i < len(quoted) && quoted[i]) {
+				r.Set(f.Name, v)
+			}
+		}
+	}
+
 	// Validate each value mapped to the respective field in the line.
 	for i, v := range row {
 		f := t.fields[i]
 
+		// Unknown column in lenient mode.
+		if f == nil {
+			continue
+		}
+
+		null := t.isNull(f, v, i < len(quoted) && quoted[i])
+		parsed := true
+
 		// Run through all the validators.
 		for _, bv := range t.Plan.FieldValidators[f.Name] {
-			if bv.Validator.RequiresValue && v == "" {
+			value := v
+
+			if null {
+				if bv.Validator.RequiresValue {
+					continue
+				}
+
+				value = ""
+			} else if bv.Validator.ChecksNull {
+				continue
+			}
+
+			if bv.Validator.DependsOnParse && !parsed {
 				continue
 			}
 
-			if verr := bv.Validate(v); verr != nil {
+			if verr := bv.Validate(value); verr != nil {
 				t.result.LogError(&ValidationError{
 					Err:     verr.Err,
 					Line:    t.csv.LineNumber(),
+					Record:  t.csv.RecordNumber(),
 					Field:   f.Name,
 					Value:   v,
 					Context: verr.Context,
 				})
 
 				t.errs++
+
+				if !t.AllErrors {
+					break
+				}
+
+				if bv.Validator.Parses {
+					parsed = false
+				}
+			}
+		}
+	}
+
+	if err := t.checkKeys(row, quoted); err != nil {
+		return err
+	}
+
+	for _, rc := range t.refColumns {
+		if t.isNull(t.fields[rc.col], row[rc.col], rc.col < len(quoted) && quoted[rc.col]) {
+			continue
+		}
+
+		if err := t.Refs.add(rc.set, row[rc.col], t.File, t.csv.LineNumber()); err != nil {
+			return err
+		}
+	}
+
+	for _, rv := range t.Plan.RowValidators {
+		verr := rv.Validate(t.Plan.Row)
+
+		if verr == nil {
+			continue
+		}
+
+		values := make([]string, len(rv.Fields))
+
+		for i, name := range rv.Fields {
+			v, _ := t.Plan.Row.Value(name)
+			values[i] = fmt.Sprintf("%s=%s", name, v)
+		}
+
+		t.result.LogError(&ValidationError{
+			Err:     verr.Err,
+			Line:    t.csv.LineNumber(),
+			Record:  t.csv.RecordNumber(),
+			Fields:  rv.Fields,
+			Value:   strings.Join(values, ", "),
+			Context: verr.Context,
+		})
+
+		t.errs++
+	}
+
+	return nil
+}
+
+// checkKeys adds the key values of the row to the key checker. Keys with
+// a null value are skipped.
+func (t *TableValidator) checkKeys(row []string, quoted []bool) error {
+	for i, cols := range t.keyColumns {
+		if cols == nil {
+			continue
+		}
+
+		null := false
+		t.keyValues = t.keyValues[:0]
+
+		for _, c := range cols {
+			if t.isNull(t.fields[c], row[c], c < len(quoted) && quoted[c]) {
+				null = true
 				break
 			}
+
+			t.keyValues = append(t.keyValues, row[c])
+		}
+
+		if null {
+			continue
+		}
+
+		if err := t.Keys.add(i, t.keyValues, t.File, t.csv.LineNumber()); err != nil {
+			return err
 		}
 	}
 
 	return nil
 }
 
+// checkOrder returns an error listing the columns that are not in the
+// position of the field they are mapped to.
+func (t *TableValidator) checkOrder() *ValidationError {
+	expected := make(map[string]int, t.length)
+
+	for i, f := range FieldOrder(t.Fields) {
+		expected[f.Name] = i + 1
+	}
+
+	var columns []string
+
+	for i, name := range t.Header {
+		f, ok := t.fields[i]
+
+		if !ok {
+			continue
+		}
+
+		if pos := expected[f.Name]; pos != i+1 {
+			columns = append(columns, fmt.Sprintf("%s (position %d, expected %d)", name, i+1, pos))
+		}
+	}
+
+	if len(columns) == 0 {
+		return nil
+	}
+
+	return &ValidationError{
+		Err:   ErrColumnOrder,
+		Value: t.csv.Line(),
+		Context: Context{
+			"columns": columns,
+		},
+	}
+}
+
+// columns returns the columns of an input without a header.
+func (t *TableValidator) columns() []string {
+	if len(t.Columns) > 0 {
+		return append([]string(nil), t.Columns...)
+	}
+
+	if cols := t.Mapping.Columns(t.table); len(cols) > 0 {
+		return cols
+	}
+
+	fields := FieldOrder(t.Fields)
+	names := make([]string, len(fields))
+
+	for i, f := range fields {
+		names[i] = f.Name
+	}
+
+	return names
+}
+
 // Init initializes the validator by checking the header and compiling
 // a set of validators for each field.
 func (t *TableValidator) Init() error {
@@ -85,7 +372,18 @@ func (t *TableValidator) Init() error {
 		matchErr  bool
 	)
 
-	if head, err = t.csv.Read(); err != nil {
+	t.csv.MaxRecordSize = t.MaxRecordSize
+	t.csv.Quoting = t.Quoting
+
+	t.nulls = make(map[string]struct{}, len(t.NullTokens))
+
+	for _, tok := range t.NullTokens {
+		t.nulls[tok] = struct{}{}
+	}
+
+	if t.NoHeader {
+		head = t.columns()
+	} else if head, err = t.csv.Read(); err != nil {
 		return err
 	}
 
@@ -103,8 +401,27 @@ func (t *TableValidator) Init() error {
 	unknown := make([]string, 0)
 	missing := make([]string, 0)
 
+	// Positions of each field in the header.
+	positions := make(map[string][]int)
+	var duplicates []string
+
 	// Check if all fields in the header are expected.
 	for i, name := range t.Header {
+		if m := t.Mapping.Field(t.table, name); m != "" {
+			name = m
+		}
+
+		positions[name] = append(positions[name], i+1)
+
+		// Only the first of duplicate columns is validated.
+		if len(positions[name]) > 1 {
+			if len(positions[name]) == 2 {
+				duplicates = append(duplicates, name)
+			}
+
+			continue
+		}
+
 		if f := t.Fields.Get(name); f != nil {
 			valid[name] = i
 			fields[i] = f
@@ -128,8 +445,35 @@ func (t *TableValidator) Init() error {
 		matchErr = true
 	}
 
+	t.suggestions = make(map[string][]string)
+
+	for _, name := range unknown {
+		if s := suggestFields(name, missing, t.Mapping, t.table); len(s) > 0 {
+			t.suggestions[name] = s
+		}
+	}
+
+	// Errors found in the header. If not lenient, the first one is returned.
+	var herrs []*ValidationError
+
+	if len(duplicates) > 0 {
+		dups := make(map[string][]int, len(duplicates))
+
+		for _, name := range duplicates {
+			dups[name] = positions[name]
+		}
+
+		herrs = append(herrs, &ValidationError{
+			Err:   ErrDuplicateColumns,
+			Value: t.csv.Line(),
+			Context: Context{
+				"positions": dups,
+			},
+		})
+	}
+
 	if lengthErr || matchErr {
-		return &ValidationError{
+		herrs = append(herrs, &ValidationError{
 			Err:   ErrBadHeader,
 			Value: t.csv.Line(),
 			Context: Context{
@@ -137,15 +481,173 @@ func (t *TableValidator) Init() error {
 				"actualLength":   len(head),
 				"unknownFields":  unknown,
 				"missingFields":  missing,
+				"suggestions":    t.suggestions,
 			},
+		})
+	}
+
+	if t.EnforceOrder {
+		if verr := t.checkOrder(); verr != nil {
+			herrs = append(herrs, verr)
 		}
 	}
 
+	if len(herrs) > 0 && !t.Lenient {
+		return herrs[0]
+	}
+
+	for _, verr := range herrs {
+		t.result.LogTableError(verr)
+	}
+
+	if lengthErr || matchErr {
+		var required []string
+
+		for _, name := range missing {
+			if t.Fields.Get(name).Required {
+				required = append(required, name)
+			}
+		}
+
+		if len(required) > 0 {
+			t.result.LogTableError(&ValidationError{
+				Err: ErrMissingRequiredColumns,
+				Context: Context{
+					"fields": required,
+				},
+			})
+		}
+
+		// Rows are expected to match the header rather than the fields.
+		t.length = len(head)
+	}
+
 	// Compile a list of validators per field.
 	t.Plan.FieldValidators = make(map[string][]*BoundValidator, len(t.fields))
 
 	for _, f := range t.fields {
-		t.Plan.FieldValidators[f.Name] = BindFieldValidators(f)
+		vs := t.Registry.Bind(t.table, f)
+		t.DateFormats.Bind(t.table, f, vs)
+
+		if len(t.TrueValues) > 0 || len(t.FalseValues) > 0 {
+			for _, bv := range vs {
+				if bv.Validator == BooleanValidator {
+					bv.Context = Context{
+						"true":  t.TrueValues,
+						"false": t.FalseValues,
+					}
+				}
+			}
+		}
+
+		t.Plan.FieldValidators[f.Name] = vs
+	}
+
+	t.Plan.RowValidators = t.Registry.RowValidators(t.table)
+	t.Plan.DateFormats = t.DateFormats
+
+	t.Registry.runHooks(t.table, t.Fields, t.Plan)
+
+	// Validators that parse the value run before those depending on it.
+	for _, vs := range t.Plan.FieldValidators {
+		sort.SliceStable(vs, func(i, j int) bool {
+			return vs[i].Validator.stage() < vs[j].Validator.stage()
+		})
+	}
+
+	// Row validators are only run if all of their fields are in the input.
+	mapped := make(map[string]bool, len(t.fields))
+
+	for _, f := range t.fields {
+		mapped[f.Name] = true
+	}
+
+	var rvs []*RowValidator
+
+	for _, rv := range t.Plan.RowValidators {
+		ok := true
+
+		for _, name := range rv.Fields {
+			if f := t.Fields.Get(name); f == nil || !mapped[f.Name] {
+				ok = false
+				break
+			}
+		}
+
+		if ok {
+			rvs = append(rvs, rv)
+		}
+	}
+
+	t.Plan.RowValidators = rvs
+
+	if t.Refs != nil {
+		t.refColumns = t.Refs.columns(t.table, t.fields)
+	}
+
+	if t.Keys != nil {
+		columns := make(map[string]int, len(t.fields))
+
+		for i, f := range t.fields {
+			columns[f.Name] = i
+		}
+
+		t.keyColumns = make([][]int, len(t.Keys.Constraints))
+
+		for i, c := range t.Keys.Constraints {
+			cols := make([]int, len(c.Fields))
+
+			for j, name := range c.Fields {
+				f := t.Fields.Get(name)
+
+				if f == nil {
+					cols = nil
+					break
+				}
+
+				col, ok := columns[f.Name]
+
+				if !ok {
+					cols = nil
+					break
+				}
+
+				cols[j] = col
+			}
+
+			t.keyColumns[i] = cols
+		}
+	}
+
+	if len(rvs) > 0 && t.Plan.Row == nil {
+		t.Plan.Row = NewRow()
+	}
+
+	if t.Plan.Row != nil {
+		t.Plan.Row.table = t.table
+		t.Plan.Row.fields = t.Fields
+		t.Plan.Row.formats = t.DateFormats
+		t.Plan.Row.trueValues = t.TrueValues
+		t.Plan.Row.falseValues = t.FalseValues
+	}
+
+	for _, f := range t.Fields.List() {
+		if KnownType(f.Type) || t.Registry.HasType(f.Type) {
+			continue
+		}
+
+		if !t.Strict {
+			log.Printf("no validator for type '%s'", f.Type)
+			continue
+		}
+
+		t.result.LogTableError(&ValidationError{
+			Err:   ErrUnknownType,
+			Field: f.Name,
+			Context: Context{
+				"type": f.Type,
+			},
+		})
 	}
 
 	return nil
@@ -156,27 +658,48 @@ func (t *TableValidator) Init() error {
 func (t *TableValidator) Next() error {
 	err := t.csv.ScanLine(t.record)
 
+	// The record was read as a whole so the values are still validated.
+	if err == csvErrUnquotedField {
+		t.logUnquoted()
+		err = nil
+	}
+
 	if err != nil {
 		switch err {
-		case csvErrUnquotedField:
-			err = ErrUnquotedColumn
 		case csvErrUnterminatedField:
 			err = ErrUnterminatedColumn
 		case csvErrUnescapedQuote:
 			err = ErrBareQuote
 		case csvErrExtraColumns:
 			err = ErrExtraColumns
+		case csvErrRecordTooLong:
+			err = ErrRecordTooLong
 		}
 
 		switch x := err.(type) {
 		case *Error:
+			value := t.csv.Line()
+			cxt := Context{
+				"column": t.csv.ColumnNumber(),
+			}
+
+			// Only keep the start of the record.
+			if x == ErrRecordTooLong {
+				if len(value) > recordSampleSize {
+					value = value[:recordSampleSize] + "..."
+				}
+
+				cxt = Context{
+					"maxSize": t.MaxRecordSize,
+				}
+			}
+
 			t.result.LogError(&ValidationError{
-				Err:   x,
-				Value: t.csv.Line(),
-				Line:  t.csv.LineNumber(),
-				Context: Context{
-					"column": t.csv.ColumnNumber(),
-				},
+				Err:     x,
+				Value:   value,
+				Line:    t.csv.LineNumber(),
+				Record:  t.csv.RecordNumber(),
+				Context: cxt,
 			})
 
 			// Return nil so caller knows to continue.
@@ -187,9 +710,71 @@ func (t *TableValidator) Next() error {
 		return err
 	}
 
+	if eol := t.csv.MixedLineEnding(); eol != "" {
+		t.result.LogError(&ValidationError{
+			Err:    ErrMixedLineEndings,
+			Value:  t.csv.Line(),
+			Line:   t.csv.LineNumber(),
+			Record: t.csv.RecordNumber(),
+			Context: Context{
+				"expected": t.csv.LineEnding(),
+				"actual":   eol,
+			},
+		})
+	}
+
 	return t.validateRow(t.record)
 }
 
+// logUnquoted logs the columns of the current record that are not quoted
+// as required by the quoting policy.
+func (t *TableValidator) logUnquoted() {
+	for _, c := range t.csv.UnquotedColumns() {
+		cxt := Context{
+			"column": c,
+			"policy": t.Quoting.String(),
+		}
+
+		if f, ok := t.fields[c-1]; ok {
+			cxt["field"] = f.Name
+		} else if c <= len(t.Header) {
+			cxt["field"] = t.Header[c-1]
+		}
+
+		t.result.LogError(&ValidationError{
+			Err:     ErrUnquotedColumn,
+			Value:   t.csv.Line(),
+			Line:    t.csv.LineNumber(),
+			Record:  t.csv.RecordNumber(),
+			Context: cxt,
+		})
+	}
+}
+
+// StarterMapping returns a mapping of each column in the header to the field
+// it matched or the closest suggested field. Columns without either are
+// mapped to an empty field to be filled in.
+func (t *TableValidator) StarterMapping() HeaderMapping {
+	m := make(HeaderMapping, len(t.Header))
+
+	for i, name := range t.Header {
+		c := &ColumnMapping{
+			Table:  t.table,
+			Column: name,
+		}
+
+		if f, ok := t.fields[i]; ok {
+			c.Field = f.Name
+		} else if s := t.suggestions[name]; len(s) > 0 {
+			c.Field = s[0]
+		}
+
+		m[i] = c
+	}
+
+	return m
+}
+
 // Run executes all of the validators for the input. All parse and validation
 // errors are handled so the only error that should stop the validator is EOF.
 func (t *TableValidator) Run() error {
@@ -213,16 +798,37 @@ func (t *TableValidator) Result() *Result {
 	return t.result
 }
 
-// New takes an io.Reader and validates it against a data model table.
-func New(reader io.Reader, table *client.Table) *TableValidator {
-	cr := DefaultCSVReader(reader)
+// New takes an io.Reader and validates it against a data model table. The
+// dialect describes the format of the data. If nil, the default dialect is used.
+// If the reader is a *Reader, the encoding it was decoded from is recorded in
+// the result.
+func New(reader io.Reader, table *client.Table, dialect *Dialect) *TableValidator {
+	cr := NewCSVReader(reader, dialect)
+	result := NewResult()
+
+	if r, ok := reader.(*Reader); ok {
+		result.Encoding = r.Encoding
+
+		if r.EncodingMismatch() {
+			result.LogTableError(&ValidationError{
+				Err: ErrEncodingMismatch,
+				Context: Context{
+					"declared": r.Encoding,
+					"detected": r.DetectedEncoding,
+				},
+			})
+		}
+	}
 
 	return &TableValidator{
-		Fields: table.Fields,
-		Plan:   new(Plan),
-		length: table.Fields.Len(),
-		reader: reader,
-		csv:    cr,
-		result: NewResult(),
+		Fields:   table.Fields,
+		Quoting:  cr.Quoting,
+		Registry: DefaultRegistry,
+		table:    table.Name,
+		Plan:     new(Plan),
+		length:   table.Fields.Len(),
+		reader:   reader,
+		csv:      cr,
+		result:   result,
 	}
 }
@@ -33,7 +33,7 @@ func BenchmarkValidateRow(b *testing.B) {
 	r.Write([]byte(header))
 	r.Write([]byte(line))
 
-	v := New(r, table)
+	v := New(r, table, nil)
 	v.Init()
 
 	buf := []byte(line)
@@ -47,3 +47,374 @@ func BenchmarkValidateRow(b *testing.B) {
 		v.validateRow(row)
 	}
 }
+
+func TestTableValidatorQuoting(t *testing.T) {
+	r := bytes.NewBufferString("\"person_id\",\"birth_date\"\n\"1\",2000-01-01\n\"2\",\"\"\n")
+
+	v := New(r, testTable(t, "person"), &Dialect{Quoting: QuoteAllNonEmpty})
+
+	if err := v.Init(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := v.Run(); err != nil {
+		t.Fatal(err)
+	}
+
+	errs := v.Result().LineErrors()[ErrUnquotedColumn]
+
+	if len(errs) != 1 {
+		t.Fatalf("expected 1 error, got %d", len(errs))
+	}
+
+	if errs[0].Line != 2 || errs[0].Context["column"] != 2 || errs[0].Context["field"] != "birth_date" {
+		t.Errorf("wrong error %s", errs[0])
+	}
+}
+
+func TestTableValidatorNulls(t *testing.T) {
+	tests := []struct {
+		EmptyStrings bool
+		Input        string
+		Errors       int
+	}{
+		{false, `1,`, 0},
+		{false, `,`, 1},
+		{false, `"",""`, 1},
+		{false, `NULL,NA`, 1},
+		{false, `"NULL",NA`, 1},
+		{false, `1,"NA"`, 1},
+		{false, `1,\N`, 0},
+		// Empty strings are only allowed in string fields.
+		{true, `"",""`, 1},
+	}
+
+	for i, test := range tests {
+		r := bytes.NewBufferString("person_id,birth_date\n" + test.Input + "\n")
+
+		v := New(r, testTable(t, "person"), nil)
+		v.NullTokens = []string{"NULL", `\N`, "NA"}
+		v.EmptyStrings = test.EmptyStrings
+
+		if err := v.Init(); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := v.Run(); err != nil {
+			t.Fatal(err)
+		}
+
+		var n int
+
+		for _, errs := range v.Result().FieldErrors("person_id") {
+			n += len(errs)
+		}
+
+		for _, errs := range v.Result().FieldErrors("birth_date") {
+			n += len(errs)
+		}
+
+		if n != test.Errors {
+			t.Errorf("[%d] expected %d errors, got %d", i, test.Errors, n)
+		}
+	}
+}
+
+func TestIsNull(t *testing.T) {
+	v := &TableValidator{
+		EmptyStrings: true,
+		nulls:        map[string]struct{}{"NULL": {}},
+	}
+
+	str := &dms.Field{Type: "string"}
+	num := &dms.Field{Type: "integer"}
+
+	tests := []struct {
+		Field  *dms.Field
+		Value  string
+		Quoted bool
+		Null   bool
+	}{
+		{str, "", false, true},
+		{str, "", true, false},
+		{num, "", true, true},
+		{str, "NULL", false, true},
+		{str, "NULL", true, false},
+		{str, "null", false, false},
+	}
+
+	for i, test := range tests {
+		if null := v.isNull(test.Field, test.Value, test.Quoted); null != test.Null {
+			t.Errorf("[%d] expected %v, got %v", i, test.Null, null)
+		}
+	}
+}
+
+func TestTableValidatorLenient(t *testing.T) {
+	input := "visit_id,birth_date\n1,2000-01-01\n2,01/01/2000\n"
+
+	v := New(bytes.NewBufferString(input), testTable(t, "person"), nil)
+
+	if err := v.Init(); err == nil {
+		t.Fatal("expected header error")
+	}
+
+	v = New(bytes.NewBufferString(input), testTable(t, "person"), nil)
+	v.Lenient = true
+
+	if err := v.Init(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := v.Run(); err != nil {
+		t.Fatal(err)
+	}
+
+	result := v.Result()
+	terrs := result.TableErrors()
+
+	if len(terrs) != 2 || terrs[0].Err != ErrBadHeader || terrs[1].Err != ErrMissingRequiredColumns {
+		t.Fatalf("expected header and missing column errors, got %v", terrs)
+	}
+
+	if fmt.Sprint(terrs[1].Context["fields"]) != "[person_id]" {
+		t.Errorf("expected missing person_id, got %v", terrs[1].Context)
+	}
+
+	if len(result.LineErrors()) != 0 {
+		t.Errorf("unexpected line errors %v", result.LineErrors())
+	}
+
+	if errs := result.FieldErrors("birth_date")[ErrTypeMismatchDate]; len(errs) != 1 || errs[0].Line != 3 {
+		t.Errorf("expected date error on line 3, got %v", errs)
+	}
+}
+
+func TestTableValidatorMapping(t *testing.T) {
+	input := "PAT_ID,birth_dt\n1,2000-01-01\n"
+
+	v := New(bytes.NewBufferString(input), testTable(t, "person"), nil)
+
+	err := v.Init()
+
+	verr, ok := err.(*ValidationError)
+
+	if !ok {
+		t.Fatalf("expected header error, got %v", err)
+	}
+
+	suggestions := verr.Context["suggestions"].(map[string][]string)
+
+	if fmt.Sprint(suggestions["birth_dt"]) != "[birth_date]" {
+		t.Errorf("expected birth_date suggestion, got %v", suggestions)
+	}
+
+	starter := v.StarterMapping()
+
+	if len(starter) != 2 || starter[0].Field != "" || starter[1].Field != "birth_date" {
+		t.Errorf("wrong starter mapping %v", starter)
+	}
+
+	v = New(bytes.NewBufferString(input), testTable(t, "person"), nil)
+	v.Mapping = HeaderMapping{
+		{Table: "person", Column: "pat_id", Field: "person_id"},
+		{Column: "birth_dt", Field: "birth_date"},
+	}
+
+	if err = v.Init(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = v.Run(); err != nil {
+		t.Fatal(err)
+	}
+
+	if errs := v.Result().FieldErrors("person_id"); len(errs) != 0 {
+		t.Errorf("unexpected errors %v", errs)
+	}
+}
+
+func TestTableValidatorNoHeader(t *testing.T) {
+	tests := []struct {
+		Columns []string
+		Mapping HeaderMapping
+		Input   string
+	}{
+		// Order the model declares the fields in, which is not sorted by name.
+		{nil, nil, "1,2000-01-01\n2,bad\n"},
+		{[]string{"person_id", "birth_date"}, nil, "1,2000-01-01\n2,bad\n"},
+		{nil, HeaderMapping{
+			{Table: "person", Column: "id", Field: "person_id"},
+			{Table: "person", Column: "dob", Field: "birth_date"},
+		}, "1,2000-01-01\n2,bad\n"},
+	}
+
+	for i, test := range tests {
+		v := New(bytes.NewBufferString(test.Input), testTable(t, "person"), nil)
+		v.NoHeader = true
+		v.Columns = test.Columns
+		v.Mapping = test.Mapping
+
+		if err := v.Init(); err != nil {
+			t.Fatalf("[%d] %s", i, err)
+		}
+
+		if err := v.Run(); err != nil {
+			t.Fatalf("[%d] %s", i, err)
+		}
+
+		errs := v.Result().FieldErrors("birth_date")[ErrTypeMismatchDate]
+
+		if len(errs) != 1 || errs[0].Line != 2 {
+			t.Errorf("[%d] expected date error on line 2, got %v", i, errs)
+		}
+	}
+}
+
+func TestTableValidatorHeaderChecks(t *testing.T) {
+	// Duplicate columns are always reported.
+	v := New(bytes.NewBufferString("person_id,birth_date,person_id\n"), testTable(t, "person"), nil)
+
+	err := v.Init()
+
+	verr, ok := err.(*ValidationError)
+
+	if !ok || verr.Err != ErrDuplicateColumns {
+		t.Fatalf("expected duplicate columns error, got %v", err)
+	}
+
+	if fmt.Sprint(verr.Context["positions"]) != "map[person_id:[1 3]]" {
+		t.Errorf("wrong positions %v", verr.Context["positions"])
+	}
+
+	// Order is only enforced if enabled.
+	input := "birth_date,person_id\n2000-01-01,1\n"
+
+	v = New(bytes.NewBufferString(input), testTable(t, "person"), nil)
+
+	if err = v.Init(); err != nil {
+		t.Errorf("unexpected error %s", err)
+	}
+
+	v = New(bytes.NewBufferString(input), testTable(t, "person"), nil)
+	v.EnforceOrder = true
+
+	err = v.Init()
+
+	if verr, ok = err.(*ValidationError); !ok || verr.Err != ErrColumnOrder {
+		t.Fatalf("expected column order error, got %v", err)
+	}
+
+	if cols := verr.Context["columns"].([]string); len(cols) != 2 || cols[0] != "birth_date (position 1, expected 2)" {
+		t.Errorf("wrong columns %v", cols)
+	}
+
+	// The order the model declares the fields in is not sorted by name.
+	v = New(bytes.NewBufferString("person_id,birth_date\n"), testTable(t, "person"), nil)
+	v.EnforceOrder = true
+
+	if err = v.Init(); err != nil {
+		t.Errorf("unexpected error %s", err)
+	}
+}
+
+func TestTableValidatorStrict(t *testing.T) {
+	fields := &dms.Fields{}
+	fields.Add(&dms.Field{Name: "id", Type: "integer"})
+	fields.Add(&dms.Field{Name: "active", Type: "bool"})
+	fields.Add(&dms.Field{Name: "shape", Type: "geometry"})
+
+	table := &dms.Table{Name: "site", Fields: fields}
+
+	input := "id,active,shape\n1,Y,POINT(0 0)\n2,1,POINT(1 1)\n"
+
+	v := New(bytes.NewBufferString(input), table, nil)
+	v.Strict = true
+	v.TrueValues = []string{"Y"}
+	v.FalseValues = []string{"N"}
+
+	if err := v.Init(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := v.Run(); err != nil {
+		t.Fatal(err)
+	}
+
+	terrs := v.Result().TableErrors()
+
+	if len(terrs) != 1 || terrs[0].Err != ErrUnknownType || terrs[0].Field != "shape" {
+		t.Errorf("expected unknown type error, got %v", terrs)
+	}
+
+	if errs := v.Result().FieldErrors("active")[ErrTypeMismatchBool]; len(errs) != 1 || errs[0].Line != 3 {
+		t.Errorf("expected boolean error on line 3, got %v", errs)
+	}
+}
+
+func TestTableValidatorAllErrors(t *testing.T) {
+	fields := &dms.Fields{}
+	fields.Add(&dms.Field{Name: "id", Type: "integer"})
+	fields.Add(&dms.Field{Name: "name", Type: "string", Length: 3})
+
+	table := &dms.Table{Name: "site", Fields: fields}
+
+	errMax := &Error{Code: 1000, Description: "Value is too large"}
+
+	r := NewRegistry()
+
+	// Registered before the type validator, but run after it.
+	r.RegisterTable("site", "id", &Validator{
+		Name:           "Max",
+		RequiresValue:  true,
+		DependsOnParse: true,
+		Validate: func(s string, cxt Context) *ValidationError {
+			if len(s) > 2 {
+				return &ValidationError{Err: errMax}
+			}
+
+			return nil
+		},
+	}, nil)
+
+	input := "id,name\n1,ab\nabc,\xffabc\n1000,abc\n"
+
+	for _, all := range []bool{false, true} {
+		v := New(bytes.NewBufferString(input), table, nil)
+		v.Registry = r
+		v.AllErrors = all
+
+		if err := v.Init(); err != nil {
+			t.Fatal(err)
+		}
+
+		if v.Plan.FieldValidators["id"][1].Validator != IntegerValidator {
+			t.Errorf("expected the integer validator before the dependent validator")
+		}
+
+		if err := v.Run(); err != nil {
+			t.Fatal(err)
+		}
+
+		ids := v.Result().FieldErrors("id")
+
+		// The dependent validator is skipped if the value does not parse.
+		if errs := ids[ErrTypeMismatchInt]; len(errs) != 1 || errs[0].Line != 3 {
+			t.Errorf("[%v] expected integer error on line 3, got %v", all, errs)
+		}
+
+		if errs := ids[errMax]; len(errs) != 1 || errs[0].Line != 4 {
+			t.Errorf("[%v] expected max error on line 4, got %v", all, errs)
+		}
+
+		names := v.Result().FieldErrors("name")
+
+		if errs := names[ErrBadEncoding]; len(errs) != 1 {
+			t.Errorf("[%v] expected encoding error, got %v", all, errs)
+		}
+
+		if errs := names[ErrLengthExceeded]; all && len(errs) != 1 || !all && len(errs) != 0 {
+			t.Errorf("[%v] wrong length errors %v", all, errs)
+		}
+	}
+}
@@ -2,11 +2,9 @@ package validator
 
 import (
 	"fmt"
-	"log"
 	"reflect"
 	"strconv"
 	"strings"
-	"time"
 	"unicode/utf8"
 
 	"github.com/chop-dbhi/data-models-service/client"
@@ -53,17 +51,40 @@ func (c Context) String() string {
 
 type ValidateFunc func(value string, cxt Context) *ValidationError
 
+// Validator validates a raw value. Validators that require a value are
+// skipped for null values. Validators that check for null values are passed
+// an empty string for null values and are skipped for all other values.
 type Validator struct {
 	Name          string
 	Description   string
 	Validate      ValidateFunc
 	RequiresValue bool
+	ChecksNull    bool
+
+	// Parses is true if the validator checks the value parses as the type
+	// of the field. DependsOnParse is true if the validator assumes it does.
+	// Validators that depend on the parse are run after the validators that
+	// parse and are skipped if one of them fails.
+	Parses         bool
+	DependsOnParse bool
 }
 
 func (v *Validator) String() string {
 	return v.Name
 }
 
+// stage returns the position of the validator in the dependency order.
+func (v *Validator) stage() int {
+	switch {
+	case v.DependsOnParse:
+		return 2
+	case v.Parses:
+		return 1
+	}
+
+	return 0
+}
+
 var EncodingValidator = &Validator{
 	Name: "Encoding",
 
@@ -130,6 +151,7 @@ var IntegerValidator = &Validator{
 	Description: "Validates the input string is a valid integer.",
 
 	RequiresValue: true,
+	Parses:        true,
 
 	Validate: func(s string, cxt Context) *ValidationError {
 		if _, err := strconv.ParseInt(s, 10, 32); err != nil {
@@ -149,6 +171,7 @@ var BigIntegerValidator = &Validator{
 	Description: "Validates the input string is a valid BigInteger.",
 
 	RequiresValue: true,
+	Parses:        true,
 
 	Validate: func(s string, cxt Context) *ValidationError {
 		if _, err := strconv.ParseInt(s, 10, 64); err != nil {
@@ -161,6 +184,47 @@ var BigIntegerValidator = &Validator{
 	},
 }
 
+// SmallIntegerValidator validates the raw value is a 16-bit integer.
+var SmallIntegerValidator = &Validator{
+	Name: "SmallInteger",
+
+	Description: "Validates the input string is a valid SmallInteger.",
+
+	RequiresValue: true,
+	Parses:        true,
+
+	Validate: func(s string, cxt Context) *ValidationError {
+		if _, err := strconv.ParseInt(s, 10, 16); err != nil {
+			return &ValidationError{
+				Err: ErrTypeMismatchSmallInt,
+			}
+		}
+
+		return nil
+	},
+}
+
+// TinyIntegerValidator validates the raw value is an integer between 0 and
+// 255 as stored by tinyint columns.
+var TinyIntegerValidator = &Validator{
+	Name: "TinyInteger",
+
+	Description: "Validates the input string is a valid TinyInteger.",
+
+	RequiresValue: true,
+	Parses:        true,
+
+	Validate: func(s string, cxt Context) *ValidationError {
+		if _, err := strconv.ParseUint(s, 10, 8); err != nil {
+			return &ValidationError{
+				Err: ErrTypeMismatchTinyInt,
+			}
+		}
+
+		return nil
+	},
+}
+
 // NumberValidator validates the raw value is a number.
 var NumberValidator = &Validator{
 	Name: "Number",
@@ -168,6 +232,7 @@ var NumberValidator = &Validator{
 	Description: "Validates the input string is a valid number (float).",
 
 	RequiresValue: true,
+	Parses:        true,
 
 	Validate: func(s string, cxt Context) *ValidationError {
 		if _, err := strconv.ParseFloat(s, 32); err != nil {
@@ -180,44 +245,286 @@ var NumberValidator = &Validator{
 	},
 }
 
-// DateValidator validates the raw value is date.
-var DateValidator = &Validator{
-	Name: "Date",
+// parseDecimal parses a decimal literal with an optional sign, fractional
+// part and exponent. It returns the number of significant integer and
+// fractional digits. Leading zeros of the integer part and trailing zeros
+// of the fractional part do not change the value and are not counted.
+func parseDecimal(s string) (intDigits, scale int, ok bool) {
+	if s != "" && (s[0] == '+' || s[0] == '-') {
+		s = s[1:]
+	}
 
-	Description: "Validates the input value is a valid date.",
+	var exp int
+
+	if i := strings.IndexAny(s, "eE"); i >= 0 {
+		e, err := strconv.Atoi(s[i+1:])
+
+		if err != nil {
+			return 0, 0, false
+		}
+
+		exp = e
+		s = s[:i]
+	}
+
+	ip, fp := s, ""
+
+	if i := strings.IndexByte(s, '.'); i >= 0 {
+		ip, fp = s[:i], s[i+1:]
+	}
+
+	if ip == "" && fp == "" {
+		return 0, 0, false
+	}
+
+	for _, p := range []string{ip, fp} {
+		for i := 0; i < len(p); i++ {
+			if p[i] < '0' || p[i] > '9' {
+				return 0, 0, false
+			}
+		}
+	}
+
+	// The significant digits and the position of the decimal point from
+	// the end of the digits.
+	digits := strings.TrimRight(strings.TrimLeft(ip+fp, "0"), "0")
+
+	if digits == "" {
+		return 0, 0, true
+	}
+
+	trailing := len(strings.TrimLeft(ip+fp, "0")) - len(digits)
+	scale = len(fp) - exp - trailing
+
+	if scale < 0 {
+		return len(digits) - scale, 0, true
+	}
+
+	if intDigits = len(digits) - scale; intDigits < 0 {
+		intDigits = 0
+	}
+
+	return intDigits, scale, true
+}
+
+// DecimalValidator validates the raw value is a decimal that fits the
+// precision and scale in the context. The literal is parsed exactly rather
+// than as a float. A precision of zero is not checked.
+var DecimalValidator = &Validator{
+	Name: "Decimal",
+
+	Description: "Validates the input string is a valid decimal within the precision and scale.",
 
 	RequiresValue: true,
+	Parses:        true,
 
 	Validate: func(s string, cxt Context) *ValidationError {
-		if _, err := time.Parse(DateLayout, s); err != nil {
-			// Since dates are a subset of datetimes, a datetime is also
-			// a valid date. The consumer will need to handle using only
-			// the date portion.
-			if err := DatetimeValidator.Validate(s, cxt); err == nil {
-				return nil
-			}
+		intDigits, scale, ok := parseDecimal(s)
 
+		if !ok {
 			return &ValidationError{
-				Err: ErrTypeMismatchDate,
+				Err: ErrTypeMismatchDecimal,
 			}
 		}
 
-		return nil
+		maxPrecision, _ := cxt["precision"].(int)
+		maxScale, _ := cxt["scale"].(int)
+
+		if maxPrecision == 0 {
+			return nil
+		}
+
+		var err *Error
+
+		// Values are stored with the scale of the field which leaves
+		// precision - scale digits for the integer part.
+		if scale > maxScale {
+			err = ErrScaleExceeded
+		} else if intDigits > maxPrecision-maxScale {
+			err = ErrPrecisionExceeded
+		}
+
+		if err == nil {
+			return nil
+		}
+
+		return &ValidationError{
+			Err: err,
+			Context: Context{
+				"precision":    intDigits + scale,
+				"scale":        scale,
+				"maxPrecision": maxPrecision,
+				"maxScale":     maxScale,
+			},
+		}
+	},
+}
+
+// validateTime validates the value against the layouts and timezone policy
+// in the context falling back to the default layouts.
+func validateTime(s string, cxt Context, defaults []string, mismatch *Error) *ValidationError {
+	layouts, _ := cxt["layouts"].([]string)
+	policy, _ := cxt["timezone"].(TimezonePolicy)
+
+	if len(layouts) == 0 {
+		layouts = defaults
+	}
+
+	layout, ok, tzok := parseTime(s, layouts, policy)
+
+	if !ok {
+		return &ValidationError{
+			Err: mismatch,
+			Context: Context{
+				"layouts": layouts,
+			},
+		}
+	}
+
+	if !tzok {
+		return &ValidationError{
+			Err: ErrTimezone,
+			Context: Context{
+				"layout":   layout,
+				"timezone": policy.String(),
+			},
+		}
+	}
+
+	return nil
+}
+
+// DateValidator validates the raw value is date. The accepted layouts and
+// timezone policy may be set in the context.
+var DateValidator = &Validator{
+	Name: "Date",
+
+	Description: "Validates the input value is a valid date.",
+
+	RequiresValue: true,
+	Parses:        true,
+
+	Validate: func(s string, cxt Context) *ValidationError {
+		// Since dates are a subset of datetimes, a datetime is also
+		// a valid date. The consumer will need to handle using only
+		// the date portion.
+		return validateTime(s, cxt, dateLayouts, ErrTypeMismatchDate)
 	},
 }
 
-// DatetimeValidator validates the raw value is date.
+// DatetimeValidator validates the raw value is date. The accepted layouts and
+// timezone policy may be set in the context.
 var DatetimeValidator = &Validator{
 	Name: "Datetime",
 
 	Description: "Validates the input value is a valid date time.",
 
 	RequiresValue: true,
+	Parses:        true,
 
 	Validate: func(s string, cxt Context) *ValidationError {
-		if _, err := time.Parse(DatetimeLayout, s); err != nil {
-			return &ValidationError{
-				Err: ErrTypeMismatchDateTime,
+		return validateTime(s, cxt, DefaultDatetimeLayouts, ErrTypeMismatchDateTime)
+	},
+}
+
+// TimeValidator validates the raw value is a time of day. The accepted
+// layouts and timezone policy may be set in the context.
+var TimeValidator = &Validator{
+	Name: "Time",
+
+	Description: "Validates the input value is a valid time.",
+
+	RequiresValue: true,
+	Parses:        true,
+
+	Validate: func(s string, cxt Context) *ValidationError {
+		return validateTime(s, cxt, DefaultTimeLayouts, ErrTypeMismatchTime)
+	},
+}
+
+var (
+	// DefaultTrueValues and DefaultFalseValues are the literals accepted
+	// for boolean values.
+	DefaultTrueValues  = []string{"true", "t", "yes", "y", "1"}
+	DefaultFalseValues = []string{"false", "f", "no", "n", "0"}
+)
+
+// BooleanValidator validates the raw value is a boolean literal. The true
+// and false literals may be set in the context and are compared
+// case-insensitively.
+var BooleanValidator = &Validator{
+	Name: "Boolean",
+
+	Description: "Validates the input value is a valid boolean.",
+
+	RequiresValue: true,
+	Parses:        true,
+
+	Validate: func(s string, cxt Context) *ValidationError {
+		trues, _ := cxt["true"].([]string)
+		falses, _ := cxt["false"].([]string)
+
+		if len(trues) == 0 {
+			trues = DefaultTrueValues
+		}
+
+		if len(falses) == 0 {
+			falses = DefaultFalseValues
+		}
+
+		for _, l := range [][]string{trues, falses} {
+			for _, v := range l {
+				if strings.EqualFold(s, v) {
+					return nil
+				}
+			}
+		}
+
+		return &ValidationError{
+			Err: ErrTypeMismatchBool,
+			Context: Context{
+				"true":  trues,
+				"false": falses,
+			},
+		}
+	},
+}
+
+// UUIDValidator validates the raw value is a UUID in the 8-4-4-4-12 hex
+// digit form. Braces around the value are accepted.
+var UUIDValidator = &Validator{
+	Name: "UUID",
+
+	Description: "Validates the input value is a valid UUID.",
+
+	RequiresValue: true,
+	Parses:        true,
+
+	Validate: func(s string, cxt Context) *ValidationError {
+		if len(s) == 38 && s[0] == '{' && s[37] == '}' {
+			s = s[1:37]
+		}
+
+		err := &ValidationError{
+			Err: ErrTypeMismatchUUID,
+		}
+
+		if len(s) != 36 {
+			return err
+		}
+
+		for i := 0; i < len(s); i++ {
+			c := s[i]
+
+			switch i {
+			case 8, 13, 18, 23:
+				if c != '-' {
+					return err
+				}
+			default:
+				if !('0' <= c && c <= '9' || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F') {
+					return err
+				}
 			}
 		}
 
@@ -225,13 +532,15 @@ var DatetimeValidator = &Validator{
 	},
 }
 
-// RequiredValidator validates the the raw value is not empty. This only applies
+// RequiredValidator validates the the raw value is not null. This only applies
 // to fields that are marked as required in the spec.
 var RequiredValidator = &Validator{
 	Name: "Required",
 
 	Description: "Validates the input value is not empty.",
 
+	ChecksNull: true,
+
 	Validate: func(s string, cxt Context) *ValidationError {
 		if s == "" {
 			return &ValidationError{
@@ -290,7 +599,13 @@ func Bind(v *Validator, cxt Context) *BoundValidator {
 	}
 }
 
-// BindFieldValidators returns a set of validators for the field.
+// IsStringType returns true if the field type holds character data.
+func IsStringType(typ string) bool {
+	return CanonicalType(typ) == "string"
+}
+
+// BindFieldValidators returns a set of validators for the field. Fields of
+// unknown types are only validated for their encoding and required values.
 func BindFieldValidators(f *client.Field) []*BoundValidator {
 	var vs []*BoundValidator
 
@@ -302,23 +617,33 @@ func BindFieldValidators(f *client.Field) []*BoundValidator {
 	}
 
 	// Add type-specific validators.
-	switch f.Type {
-	case "string", "clob", "text":
+	switch CanonicalType(f.Type) {
+	case "string":
 		if f.Length > 0 {
 			vs = append(vs, Bind(StringLengthValidator, Context{"length": f.Length}))
 		}
 	case "integer":
 		vs = append(vs, Bind(IntegerValidator, nil))
 	case "biginteger":
-		vs = append(vs, Bind(BigIntegerValidator, nil))	
-	case "number", "float", "decimal":
+		vs = append(vs, Bind(BigIntegerValidator, nil))
+	case "smallinteger":
+		vs = append(vs, Bind(SmallIntegerValidator, nil))
+	case "tinyinteger":
+		vs = append(vs, Bind(TinyIntegerValidator, nil))
+	case "number":
 		vs = append(vs, Bind(NumberValidator, nil))
+	case "decimal":
+		vs = append(vs, Bind(DecimalValidator, Context{"precision": f.Precision, "scale": f.Scale}))
 	case "date":
 		vs = append(vs, Bind(DateValidator, nil))
-	case "datetime", "timestamp":
+	case "datetime":
 		vs = append(vs, Bind(DatetimeValidator, nil))
-	default:
-		log.Printf("no validator for type '%s'", f.Type)
+	case "time":
+		vs = append(vs, Bind(TimeValidator, nil))
+	case "boolean":
+		vs = append(vs, Bind(BooleanValidator, nil))
+	case "uuid":
+		vs = append(vs, Bind(UUIDValidator, nil))
 	}
 
 	return vs
@@ -1,6 +1,10 @@
 package validator
 
-import "testing"
+import (
+	"testing"
+
+	dms "github.com/chop-dbhi/data-models-service/client"
+)
 
 func TestDateValidator(t *testing.T) {
 	if err := DateValidator.Validate("2014-03-20", nil); err != nil {
@@ -11,3 +15,296 @@ func TestDateValidator(t *testing.T) {
 		t.Errorf("Unexpected error when parsing datetime: %s", err)
 	}
 }
+
+func TestParseDecimal(t *testing.T) {
+	tests := []struct {
+		Value     string
+		IntDigits int
+		Scale     int
+		OK        bool
+	}{
+		{"123.45", 3, 2, true},
+		{"-123.45", 3, 2, true},
+		{"+0.005", 0, 3, true},
+		{"007.50", 1, 1, true},
+		{"12.", 2, 0, true},
+		{".5", 0, 1, true},
+		{"0", 0, 0, true},
+		{"1.5e3", 4, 0, true},
+		{"12E-3", 0, 3, true},
+		{"100", 3, 0, true},
+		{"", 0, 0, false},
+		{".", 0, 0, false},
+		{"1.2.3", 0, 0, false},
+		{"1e", 0, 0, false},
+		{"abc", 0, 0, false},
+		{"1,5", 0, 0, false},
+		{"NaN", 0, 0, false},
+	}
+
+	for _, test := range tests {
+		i, s, ok := parseDecimal(test.Value)
+
+		if ok != test.OK || i != test.IntDigits || s != test.Scale {
+			t.Errorf("%q: expected (%d, %d, %v), got (%d, %d, %v)", test.Value, test.IntDigits, test.Scale, test.OK, i, s, ok)
+		}
+	}
+}
+
+func TestDecimalValidator(t *testing.T) {
+	cxt := Context{"precision": 5, "scale": 2}
+
+	tests := []struct {
+		Value string
+		Err   *Error
+	}{
+		{"123.45", nil},
+		{"-999.99", nil},
+		{"0.10", nil},
+		{"123.456", ErrScaleExceeded},
+		{"1234.5", ErrPrecisionExceeded},
+		{"99999999999999999999.1", ErrPrecisionExceeded},
+		{"1e10", ErrPrecisionExceeded},
+		{"12x", ErrTypeMismatchDecimal},
+	}
+
+	for _, test := range tests {
+		err := DecimalValidator.Validate(test.Value, cxt)
+
+		if test.Err == nil {
+			if err != nil {
+				t.Errorf("%q: unexpected error %s", test.Value, err)
+			}
+
+			continue
+		}
+
+		if err == nil || err.Err != test.Err {
+			t.Errorf("%q: expected %s, got %v", test.Value, test.Err, err)
+		}
+	}
+
+	err := DecimalValidator.Validate("1234.567", cxt)
+
+	if err.Context["scale"] != 3 || err.Context["maxScale"] != 2 || err.Context["precision"] != 7 || err.Context["maxPrecision"] != 5 {
+		t.Errorf("wrong context %v", err.Context)
+	}
+
+	// Without a precision only the literal is checked.
+	if err := DecimalValidator.Validate("123456789.123456789", Context{"precision": 0, "scale": 0}); err != nil {
+		t.Errorf("unexpected error %s", err)
+	}
+}
+
+func TestDatetimeValidator(t *testing.T) {
+	for _, v := range []string{
+		"2015-08-20 12:14:14",
+		"2015-08-20 12:14:14.0",
+		"2015-08-20T12:14:14",
+		"2015-08-20T12:14:14.123456Z",
+		"2015-08-20T12:14:14+05:30",
+		"2015-08-20T12:14:14-0500",
+		"2015-08-20T12:14",
+	} {
+		if err := DatetimeValidator.Validate(v, nil); err != nil {
+			t.Errorf("%q: unexpected error %s", v, err)
+		}
+	}
+
+	err := DatetimeValidator.Validate("08/20/2015 12:14", nil)
+
+	if err == nil || err.Err != ErrTypeMismatchDateTime {
+		t.Fatalf("expected datetime error, got %v", err)
+	}
+
+	if layouts := err.Context["layouts"].([]string); len(layouts) != len(DefaultDatetimeLayouts) {
+		t.Errorf("expected the default layouts in the context, got %v", layouts)
+	}
+
+	cxt := Context{"layouts": []string{"01/02/2006 15:04"}}
+
+	if err := DatetimeValidator.Validate("08/20/2015 12:14", cxt); err != nil {
+		t.Errorf("unexpected error %s", err)
+	}
+
+	if err := DatetimeValidator.Validate("2015-08-20 12:14:14", cxt); err == nil {
+		t.Error("expected error for layout that is not accepted")
+	}
+}
+
+func TestTimezonePolicy(t *testing.T) {
+	tests := []struct {
+		Policy TimezonePolicy
+		Value  string
+		OK     bool
+	}{
+		{TimezoneAny, "2015-08-20T12:14:14", true},
+		{TimezoneAny, "2015-08-20T12:14:14+05:30", true},
+		{TimezoneRequired, "2015-08-20T12:14:14", false},
+		{TimezoneRequired, "2015-08-20T12:14:14Z", true},
+		{TimezoneNone, "2015-08-20T12:14:14Z", false},
+		{TimezoneNone, "2015-08-20 12:14:14", true},
+		{TimezoneUTC, "2015-08-20T12:14:14+00:00", true},
+		{TimezoneUTC, "2015-08-20T12:14:14", true},
+		{TimezoneUTC, "2015-08-20T12:14:14-05:00", false},
+	}
+
+	for _, test := range tests {
+		err := DatetimeValidator.Validate(test.Value, Context{"timezone": test.Policy})
+
+		if test.OK && err != nil {
+			t.Errorf("%s %q: unexpected error %s", test.Policy, test.Value, err)
+		} else if !test.OK && (err == nil || err.Err != ErrTimezone) {
+			t.Errorf("%s %q: expected timezone error, got %v", test.Policy, test.Value, err)
+		}
+	}
+
+	// The policy is only bound to datetime fields.
+	d := &DateFormats{Timezone: TimezoneRequired}
+
+	for _, test := range []struct {
+		Type  string
+		Value string
+		OK    bool
+	}{
+		{"date", "2015-08-20", true},
+		{"time", "12:14:14", true},
+		{"datetime", "2015-08-20T12:14:14", false},
+	} {
+		vs := BindFieldValidators(&dms.Field{Name: "f", Type: test.Type})
+		d.Bind("t", &dms.Field{Name: "f", Type: test.Type}, vs)
+
+		err := vs[len(vs)-1].Validate(test.Value)
+
+		if test.OK && err != nil {
+			t.Errorf("%s %q: unexpected error %s", test.Type, test.Value, err)
+		} else if !test.OK && (err == nil || err.Err != ErrTimezone) {
+			t.Errorf("%s %q: expected timezone error, got %v", test.Type, test.Value, err)
+		}
+	}
+
+	if _, err := ParseTimezonePolicy("local"); err == nil {
+		t.Error("expected error for unknown policy")
+	}
+}
+
+func TestDateFormatsLayouts(t *testing.T) {
+	d := &DateFormats{
+		Date: []string{"01/02/2006"},
+		Fields: map[string][]string{
+			"person.birth_date": {"20060102"},
+			"visit_start_date":  {"2006.01.02"},
+		},
+	}
+
+	tests := []struct {
+		Table  string
+		Field  *dms.Field
+		Layout string
+	}{
+		{"person", &dms.Field{Name: "birth_date", Type: "date"}, "20060102"},
+		{"death", &dms.Field{Name: "birth_date", Type: "date"}, "01/02/2006"},
+		{"visit", &dms.Field{Name: "visit_start_date", Type: "date"}, "2006.01.02"},
+		{"visit", &dms.Field{Name: "visit_start_time", Type: "datetime"}, ""},
+	}
+
+	for _, test := range tests {
+		var layout string
+
+		if l := d.Layouts(test.Table, test.Field); len(l) > 0 {
+			layout = l[0]
+		}
+
+		if layout != test.Layout {
+			t.Errorf("%s.%s: expected %q, got %q", test.Table, test.Field.Name, test.Layout, layout)
+		}
+	}
+
+	if l := ExpandLayouts([]string{"ISO8601", DateLayout}); len(l) != len(ISO8601Layouts)+1 {
+		t.Errorf("wrong layouts %v", l)
+	}
+}
+
+func TestTypeValidators(t *testing.T) {
+	tests := []struct {
+		Validator *Validator
+		Value     string
+		Err       *Error
+	}{
+		{SmallIntegerValidator, "-32768", nil},
+		{SmallIntegerValidator, "32768", ErrTypeMismatchSmallInt},
+		{TinyIntegerValidator, "255", nil},
+		{TinyIntegerValidator, "256", ErrTypeMismatchTinyInt},
+		{TinyIntegerValidator, "-1", ErrTypeMismatchTinyInt},
+		{TimeValidator, "13:45:01", nil},
+		{TimeValidator, "13:45:01.250", nil},
+		{TimeValidator, "13:45", nil},
+		{TimeValidator, "25:00", ErrTypeMismatchTime},
+		{BooleanValidator, "TRUE", nil},
+		{BooleanValidator, "n", nil},
+		{BooleanValidator, "0", nil},
+		{BooleanValidator, "maybe", ErrTypeMismatchBool},
+		{UUIDValidator, "123e4567-e89b-12d3-a456-426614174000", nil},
+		{UUIDValidator, "{123E4567-E89B-12D3-A456-426614174000}", nil},
+		{UUIDValidator, "123e4567e89b12d3a456426614174000", ErrTypeMismatchUUID},
+		{UUIDValidator, "123e4567-e89b-12d3-a456-42661417400g", ErrTypeMismatchUUID},
+	}
+
+	for _, test := range tests {
+		err := test.Validator.Validate(test.Value, nil)
+
+		if test.Err == nil {
+			if err != nil {
+				t.Errorf("%s %q: unexpected error %s", test.Validator, test.Value, err)
+			}
+		} else if err == nil || err.Err != test.Err {
+			t.Errorf("%s %q: expected %s, got %v", test.Validator, test.Value, test.Err, err)
+		}
+	}
+
+	cxt := Context{"true": []string{"Y"}, "false": []string{"N"}}
+
+	if err := BooleanValidator.Validate("y", cxt); err != nil {
+		t.Errorf("unexpected error %s", err)
+	}
+
+	if err := BooleanValidator.Validate("1", cxt); err == nil {
+		t.Error("expected error for literal that is not accepted")
+	}
+}
+
+func TestBindFieldValidators(t *testing.T) {
+	tests := []struct {
+		Type      string
+		Validator *Validator
+	}{
+		{"integer", IntegerValidator},
+		{"INT", IntegerValidator},
+		{"smallint", SmallIntegerValidator},
+		{"tinyint", TinyIntegerValidator},
+		{"numeric", DecimalValidator},
+		{"timestamp", DatetimeValidator},
+		{"time", TimeValidator},
+		{"bool", BooleanValidator},
+		{"uuid", UUIDValidator},
+		{"geometry", nil},
+	}
+
+	for _, test := range tests {
+		vs := BindFieldValidators(&dms.Field{Name: "f", Type: test.Type})
+
+		var v *Validator
+
+		if len(vs) > 1 {
+			v = vs[len(vs)-1].Validator
+		}
+
+		if v != test.Validator {
+			t.Errorf("%s: expected %v, got %v", test.Type, test.Validator, v)
+		}
+
+		if KnownType(test.Type) != (test.Validator != nil) {
+			t.Errorf("%s: wrong known type", test.Type)
+		}
+	}
+}